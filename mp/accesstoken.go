@@ -2,21 +2,13 @@
 package mp
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"strings"
+	"context"
+	"errors"
+	"net/url"
 
 	"github.com/bigwhite/gowechat/pb"
 )
 
-const (
-	accessTokenFetchURL    = "https://api.weixin.qq.com/cgi-bin/token"
-	webAccessTokenFetchUrl = "https://api.weixin.qq.com/sns/oauth2/access_token"
-)
-
 type WebAccessTokenResponse struct {
 	AccessToken  string  `json:"access_token"`
 	ExpiresIn    float64 `json:"expires_in"`
@@ -25,49 +17,60 @@ type WebAccessTokenResponse struct {
 	Scope        string  `json:"scope"`
 }
 
-// FetchAccessToken could be used to fetch access token for wechat qy dev.
+// FetchAccessToken could be used to fetch access token for wechat mp dev.
 func FetchAccessToken(appID, appSecret string) (string, float64, error) {
-	requestLine := strings.Join([]string{accessTokenFetchURL,
-		"?grant_type=client_credential&appid=",
-		appID,
-		"&secret=",
-		appSecret}, "")
+	return FetchAccessTokenContext(context.Background(), appID, appSecret)
+}
+
+// FetchAccessTokenContext is like FetchAccessToken with a context.
+func FetchAccessTokenContext(ctx context.Context, appID, appSecret string) (string, float64, error) {
+	return NewClient(appID, appSecret).FetchAccessTokenContext(ctx)
+}
+
+// FetchAccessToken fetches the access token of c.AppID.
+func (c *Client) FetchAccessToken() (string, float64, error) {
+	return c.FetchAccessTokenContext(context.Background())
+}
+
+// FetchAccessTokenContext is like FetchAccessToken with a context.
+func (c *Client) FetchAccessTokenContext(ctx context.Context) (string, float64, error) {
+	query := url.Values{}
+	query.Set("grant_type", "client_credential")
+	query.Set("appid", c.AppID)
+	query.Set("secret", c.AppSecret)
 
-	return pb.FetchAccessToken(requestLine)
+	return pb.FetchAccessTokenContext(ctx, c.Client, accessTokenFetchPath, query)
 }
 
 // url:https://api.weixin.qq.com/sns/oauth2/access_token?appid=APPID&secret=SECRET&code=CODE&grant_type=authorization_code
 func FetchWebAuthInfo(appID, appSecret, code string) (*WebAccessTokenResponse, error) {
-	requestLine := strings.Join([]string{webAccessTokenFetchUrl,
-		"?appid=", appID, "&secret=", appSecret, "&code=", code,
-		"&grant_type=authorization_code"}, "")
+	return FetchWebAuthInfoContext(context.Background(), appID, appSecret, code)
+}
 
-	resp, err := http.Get(requestLine)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return nil, err
-	}
+// FetchWebAuthInfoContext is like FetchWebAuthInfo with a context.
+func FetchWebAuthInfoContext(ctx context.Context, appID, appSecret, code string) (*WebAccessTokenResponse, error) {
+	return NewClient(appID, appSecret).FetchWebAuthInfoContext(ctx, code)
+}
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+// FetchWebAuthInfo exchanges the oauth2 code for the web access token of c.AppID.
+func (c *Client) FetchWebAuthInfo(code string) (*WebAccessTokenResponse, error) {
+	return c.FetchWebAuthInfoContext(context.Background(), code)
+}
+
+// FetchWebAuthInfoContext is like FetchWebAuthInfo with a context.
+func (c *Client) FetchWebAuthInfoContext(ctx context.Context, code string) (*WebAccessTokenResponse, error) {
+	query := url.Values{}
+	query.Set("appid", c.AppID)
+	query.Set("secret", c.AppSecret)
+	query.Set("code", code)
+	query.Set("grant_type", "authorization_code")
+
+	atr := &WebAccessTokenResponse{}
+	if err := c.DoJSON(ctx, webAccessTokenFetchPath, query, nil, atr); err != nil {
 		return nil, err
 	}
-
-	//Json Decoding
-	if bytes.Contains(body, []byte("access_token")) {
-		atr := WebAccessTokenResponse{}
-		err = json.Unmarshal(body, &atr)
-		if err != nil {
-			return nil, err
-		}
-		return &atr, nil
-	} else {
-		fmt.Println("return err")
-		ater := pb.AccessTokenErrorResponse{}
-		err = json.Unmarshal(body, &ater)
-		if err != nil {
-			return nil, err
-		}
-		return nil, fmt.Errorf("%s", ater.Errmsg)
+	if atr.AccessToken == "" {
+		return nil, errors.New("no access_token in the result")
 	}
+	return atr, nil
 }
@@ -0,0 +1,51 @@
+// Package mp provides the api client for wechat mp dev.
+package mp
+
+import (
+	"context"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+const (
+	// DefaultBaseURL is the location of wechat mp api.
+	DefaultBaseURL = "https://api.weixin.qq.com"
+
+	accessTokenFetchPath    = "/cgi-bin/token"
+	webAccessTokenFetchPath = "/sns/oauth2/access_token"
+	menuCreatePath          = "/cgi-bin/menu/create"
+	sendPath                = "/cgi-bin/message/custom/send"
+)
+
+// Client is used to call wechat mp api on behalf of one app.
+// The embedded pb.Client could be adjusted to change the base url,
+// the http client and the timeout. Its TokenSource is Tokens.
+//
+// A Client should be created by NewClient and shared by all the callers,
+// so that the access token cached in Tokens is shared too.
+type Client struct {
+	*pb.Client
+	AppID     string
+	AppSecret string
+	Tokens    *pb.TokenManager
+}
+
+// NewClient creates a Client for app appID which talks to DefaultBaseURL.
+func NewClient(appID, appSecret string) *Client {
+	c := &Client{
+		Client:    pb.NewClient(DefaultBaseURL),
+		AppID:     appID,
+		AppSecret: appSecret,
+	}
+	c.Tokens = pb.NewTokenManager(func(ctx context.Context) (string, float64, error) {
+		return c.FetchAccessTokenContext(ctx)
+	})
+	c.TokenSource = c.Tokens
+	c.Tokens.Key = "mp:" + appID
+	return c
+}
+
+// Token returns the cached access token of c, fetching a new one if needed.
+func (c *Client) Token(ctx context.Context) (string, error) {
+	return c.Tokens.Token(ctx)
+}
@@ -0,0 +1,91 @@
+package mp_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bigwhite/gowechat/mp"
+	"github.com/bigwhite/gowechat/pb"
+)
+
+const (
+	appID       = "wx5823bf96d3bd56c7"
+	appSecret   = "p_VQovLdSPNXP0caBalViFvAG_mIpR4bECn"
+	accessToken = "accesstoken000001"
+)
+
+// newStandInServer creates a stand-in server for wechat mp api, and a Client
+// talking to it.
+func newStandInServer(mux *http.ServeMux) (*httptest.Server, *mp.Client) {
+	mux.HandleFunc("/cgi-bin/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("appid") != appID || r.URL.Query().Get("secret") != appSecret {
+			w.Write([]byte(`{"errcode": 40013, "errmsg": "invalid appid"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token": "` + accessToken + `", "expires_in": 7200}`))
+	})
+	ts := httptest.NewServer(mux)
+
+	c := mp.NewClient(appID, appSecret)
+	c.BaseURL = ts.URL
+	return ts, c
+}
+
+func TestClientSendMsg(t *testing.T) {
+	var sent int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cgi-bin/message/custom/send", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") != accessToken {
+			w.Write([]byte(`{"errcode": 40014, "errmsg": "invalid access_token"}`))
+			return
+		}
+		atomic.AddInt32(&sent, 1)
+		w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+	})
+	ts, c := newStandInServer(mux)
+	defer ts.Close()
+
+	pkg := &pb.SendMsgTextPkg{
+		ToUser:  "oDF3iY9ffA-hqb2vVvbr7qxf6A0Q",
+		MsgType: "text",
+		Text:    pb.TextContent{Content: "hello body"},
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.SendMsgContext(context.Background(), pkg); err != nil {
+			t.Fatal("SendMsg error:", err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&sent); n != 2 {
+		t.Errorf("Sent: want[%d], but actually[%d]", 2, n)
+	}
+}
+
+func TestClientTokenCanceled(t *testing.T) {
+	done := make(chan struct{})
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	defer close(done)
+	mux.HandleFunc("/cgi-bin/token", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	})
+
+	c := mp.NewClient(appID, appSecret)
+	c.BaseURL = ts.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := c.SendMsgContext(ctx, &pb.SendMsgTextPkg{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Err: want[%v], but actually[%v]", context.DeadlineExceeded, err)
+	}
+}
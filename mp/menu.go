@@ -2,16 +2,30 @@
 package mp
 
 import (
-	"strings"
+	"context"
+	"net/url"
 
 	"github.com/bigwhite/gowechat/pb"
 )
 
-const (
-	url = "https://api.weixin.qq.com/cgi-bin/menu/create"
-)
-
 func CreateMenu(menuLayout []byte, accessToken, agentID string) error {
-	reqLine := strings.Join([]string{url, "?access_token=", accessToken}, "")
-	return pb.CreateMenu(reqLine, menuLayout)
+	return CreateMenuContext(context.Background(), menuLayout, accessToken, agentID)
+}
+
+// CreateMenuContext is like CreateMenu with a context.
+func CreateMenuContext(ctx context.Context, menuLayout []byte, accessToken, agentID string) error {
+	query := url.Values{}
+	query.Set("access_token", accessToken)
+	return pb.CreateMenuContext(ctx, pb.NewClient(DefaultBaseURL), menuCreatePath, query, menuLayout)
+}
+
+// CreateMenu creates the custom menu described by menuLayout
+// with the access token of c.
+func (c *Client) CreateMenu(menuLayout []byte) error {
+	return c.CreateMenuContext(context.Background(), menuLayout)
+}
+
+// CreateMenuContext is like CreateMenu with a context.
+func (c *Client) CreateMenuContext(ctx context.Context, menuLayout []byte) error {
+	return pb.CreateMenuContext(ctx, c.Client, menuCreatePath, nil, menuLayout)
 }
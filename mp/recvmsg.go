@@ -28,6 +28,22 @@ const (
 	MenuClickEvent   = "CLICK"
 	MenuViewEvent    = "VIEW"
 	ScanEvent        = "SCAN"
+
+	// Menu event type, besides CLICK and VIEW
+	ScanCodePushEvent        = "scancode_push"
+	ScanCodeWaitEvent        = "scancode_waitmsg"
+	PicSysPhotoEvent         = "pic_sysphoto"
+	PicPhotoOrAlbumEvent     = "pic_photo_or_album"
+	PicWeiXinEvent           = "pic_weixin"
+	LocationSelectEvent      = "location_select"
+	MenuViewMiniprogramEvent = "view_miniprogram"
+
+	TemplateSendJobFinishEvent = "TEMPLATESENDJOBFINISH"
+	MassSendJobFinishEvent     = "MASSSENDJOBFINISH"
+
+	// Status of send job finish event, besides the failure ones
+	TemplateSendSuccess = "success"
+	MassSendSuccess     = "send success"
 )
 
 // RecvTextDataPkg is a Text Message received from wechat platform.
@@ -124,11 +140,114 @@ type RecvLocationEventDataPkg struct {
 }
 
 // RecvMenuEventDataPkg is a Menu Click event Message
-// received from wechat platform.
+// received from wechat platform. It is a View or View Miniprogram event
+// too, whose EventKey is the url or the page path of the miniprogram.
+// MenuID is the id of the conditional menu, if any.
 type RecvMenuEventDataPkg struct {
 	pb.RecvBaseDataPkg
 	Event    string
 	EventKey string
+	MenuID   string `xml:"MenuId"`
+}
+
+// ScanCodeInfo is the scan result of a scancode menu event.
+type ScanCodeInfo = pb.ScanCodeInfo
+
+// PicItem is a picture sent by a pic menu event.
+type PicItem = pb.PicItem
+
+// SendPicsInfo is the pictures sent by a pic menu event.
+type SendPicsInfo = pb.SendPicsInfo
+
+// SendLocationInfo is the location sent by a location_select menu event.
+type SendLocationInfo = pb.SendLocationInfo
+
+// RecvScanCodeEventDataPkg is a scancode_push or scancode_waitmsg menu
+// event Message received from wechat platform.
+type RecvScanCodeEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event        string
+	EventKey     string
+	MenuID       string `xml:"MenuId"`
+	ScanCodeInfo ScanCodeInfo
+}
+
+// RecvPicEventDataPkg is a pic_sysphoto, pic_photo_or_album or pic_weixin
+// menu event Message received from wechat platform.
+type RecvPicEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event        string
+	EventKey     string
+	MenuID       string `xml:"MenuId"`
+	SendPicsInfo SendPicsInfo
+}
+
+// RecvLocationSelectEventDataPkg is a location_select menu event Message
+// received from wechat platform.
+type RecvLocationSelectEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event            string
+	EventKey         string
+	MenuID           string `xml:"MenuId"`
+	SendLocationInfo SendLocationInfo
+}
+
+// RecvTemplateSendJobFinishEventDataPkg is a template message send job
+// finish event Message received from wechat platform. Status is
+// TemplateSendSuccess, or the reason of the failure, such as
+// "failed:user block".
+type RecvTemplateSendJobFinishEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event  string
+	MsgID  uint64 `xml:"MsgID"`
+	Status string
+}
+
+// CopyrightCheckItem is the copyright check result of an article.
+type CopyrightCheckItem struct {
+	ArticleIdx            int
+	UserDeclareState      int
+	AuditState            int
+	OriginalArticleURL    string `xml:"OriginalArticleUrl"`
+	OriginalArticleType   int
+	CanReprint            int
+	NeedReplaceContent    int
+	NeedShowReprintSource int
+}
+
+// CopyrightCheckResult is the copyright check result of a mass message.
+type CopyrightCheckResult struct {
+	Count      int
+	ResultList []CopyrightCheckItem `xml:"ResultList>item"`
+	CheckState int
+}
+
+// ArticleURLItem is the url of a sent article.
+type ArticleURLItem struct {
+	ArticleIdx int
+	ArticleURL string `xml:"ArticleUrl"`
+}
+
+// ArticleURLResult is the urls of the articles of a mass message.
+type ArticleURLResult struct {
+	Count      int
+	ResultList []ArticleURLItem `xml:"ResultList>item"`
+}
+
+// RecvMassSendJobFinishEventDataPkg is a mass message send job finish event
+// Message received from wechat platform. Status is MassSendSuccess, or the
+// reason of the failure, such as "send fail" or "err(10001)".
+type RecvMassSendJobFinishEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event                string
+	MsgID                uint64 `xml:"MsgID"`
+	Status               string
+	TotalCount           int
+	FilterCount          int
+	SentCount            int
+	ErrorCount           int
+	CopyrightCheckResult CopyrightCheckResult
+	ArticleURLResult     ArticleURLResult `xml:"ArticleUrlResult"`
 }
 
 // RecvVoiceRecognitionDataPkg is a Voice recognition Message received from wechat platform.
@@ -140,41 +259,26 @@ type RecvVoiceRecognitionDataPkg struct {
 	MsgID       uint64 `xml:"MsgId"`
 }
 
-// RecvHTTPEncryptReqBody is a unmarshall result for below xml data:
-// <xml>
-//  <ToUserName><![CDATA[toUser]]</ToUserName>
-//  <Encrypt><![CDATA[msg_encrypt]]</Encrypt>
-// </xml>
-type RecvHTTPEncryptReqBody struct {
-	ToUserName string
-	Encrypt    string
-}
+// RecvHTTPEncryptReqBody is the encrypted request body of wechat mp.
+type RecvHTTPEncryptReqBody = pb.EncryptReqBody
 
-// RecvHTTPEncryptResqBody is a source for marshalling below xml data:
-// <xml>
-//  <Encrypt><![CDATA[msg_encrypt]]></Encrypt>
-//  <MsgSignature><![CDATA[msg_signature]]></MsgSignature>
-//  <TimeStamp>timestamp</TimeStamp>
-//  <Nonce><![CDATA[nonce]]></Nonce>
-// </xml>
-type RecvHTTPEncryptRespBody struct {
-	XMLName      xml.Name `xml:"xml"`
-	Encrypt      pb.CDATAText
-	MsgSignature pb.CDATAText
-	TimeStamp    int
-	Nonce        pb.CDATAText
-}
+// RecvHTTPEncryptRespBody is the encrypted response body to wechat mp.
+type RecvHTTPEncryptRespBody = pb.EncryptRespBody
 
 type recvHandler struct {
-	appID          string
-	token          string
-	encodingAESKey string
+	token string
+	guard *pb.ReplayGuard
+
+	// env is nil with envErr if encodingAESKey is invalid, which is
+	// reported when a message is encrypted.
+	env    *pb.Envelope
+	envErr error
 }
 
 // ValidateSignature is used to validate the signature in request to figure out
 // whether the http request come from wechat mp platform.
 func ValidateSignature(signature, token, timestamp, nonce string) bool {
-	return signature == genSignature(token, timestamp, nonce)
+	return pb.SignatureEqual(signature, genSignature(token, timestamp, nonce))
 }
 
 func genSignature(token, timestamp, nonce string) string {
@@ -183,10 +287,14 @@ func genSignature(token, timestamp, nonce string) string {
 
 // NewRecvHandler creates an instance of recvHandler
 // which implements pb.RecvHandler interface.
-func NewRecvHandler(appID, token, encodingAESKey string) pb.RecvHandler {
-	return &recvHandler{appID: appID,
-		token:          token,
-		encodingAESKey: encodingAESKey}
+// pb.WithReplayGuard in opts rejects the replayed requests.
+func NewRecvHandler(appID, token, encodingAESKey string, opts ...pb.RecvOption) pb.RecvHandler {
+	o := pb.NewRecvOptions(opts...)
+	env, err := pb.NewEnvelope(appID, token, encodingAESKey)
+	return &recvHandler{token: token,
+		guard:  o.Guard.WithScope(appID),
+		env:    env,
+		envErr: err}
 }
 
 // Parse used to parse the receive "post" data request.
@@ -194,30 +302,48 @@ func NewRecvHandler(appID, token, encodingAESKey string) pb.RecvHandler {
 //
 // Note: We suppose that r.ParseForm() has been invoked before entering this method.
 // and we suppose that you have validate the URL in the post request.
-func (h *recvHandler) Parse(bodyText []byte, signature, timestamp, nonce, encryptType string) (interface{}, error) {
+//
+// signature is validated for all the requests, and msgSignature, the
+// msg_signature in the request, is validated over the Encrypt field as qy
+// does if encryptType is "aes". It handles both the safe mode, in which the
+// body has the Encrypt field only, and the compatible mode, in which the
+// body has the plaintext fields besides the Encrypt field. The message is
+// parsed from the Encrypt field in both modes.
+func (h *recvHandler) Parse(bodyText []byte, signature, msgSignature, timestamp, nonce, encryptType string) (interface{}, error) {
 	var err error
-	var appID string
 	var origData []byte
 
 	if valid := ValidateSignature(signature, h.token, timestamp, nonce); !valid {
-		return nil, errors.New("validate signature error")
+		return nil, pb.ErrInvalidSignature
 	}
 
+	var reqBody *RecvHTTPEncryptReqBody
 	if encryptType == "aes" {
+		if h.envErr != nil {
+			return nil, h.envErr
+		}
 		// Decoding the body.
-		pkg := &RecvHTTPEncryptReqBody{}
-		err = xml.Unmarshal(bodyText, pkg)
-		if err != nil {
+		if reqBody, err = h.env.ParseBody(bodyText); err != nil {
 			return nil, err
 		}
-		// Decrypt the Encrypt field.
-		origData, _, appID, err = DecryptMsg(pkg.Encrypt, h.encodingAESKey)
-		if err != nil {
+		if reqBody.Encrypt == "" {
+			return nil, errors.New("no Encrypt in the body")
+		}
+		if !h.env.Verify(msgSignature, timestamp, nonce, reqBody.Encrypt) {
+			return nil, pb.ErrInvalidSignature
+		}
+	}
+
+	if h.guard != nil {
+		if err = h.guard.Check(timestamp, nonce); err != nil {
 			return nil, err
 		}
+	}
 
-		if appID != h.appID {
-			return nil, fmt.Errorf("the request is from app[%s], not from app[%s]", appID, h.appID)
+	if reqBody != nil {
+		// Decrypt the Encrypt field.
+		if origData, err = h.env.Decrypt(reqBody.Encrypt); err != nil {
+			return nil, err
 		}
 	} else {
 		origData = bodyText
@@ -268,8 +394,18 @@ func (h *recvHandler) Parse(bodyText []byte, signature, timestamp, nonce, encryp
 			dataPkg = &RecvScanEventDataPkg{}
 		case LocationEvent:
 			dataPkg = &RecvLocationEventDataPkg{}
-		case MenuClickEvent, MenuViewEvent:
+		case MenuClickEvent, MenuViewEvent, MenuViewMiniprogramEvent:
 			dataPkg = &RecvMenuEventDataPkg{}
+		case ScanCodePushEvent, ScanCodeWaitEvent:
+			dataPkg = &RecvScanCodeEventDataPkg{}
+		case PicSysPhotoEvent, PicPhotoOrAlbumEvent, PicWeiXinEvent:
+			dataPkg = &RecvPicEventDataPkg{}
+		case LocationSelectEvent:
+			dataPkg = &RecvLocationSelectEventDataPkg{}
+		case TemplateSendJobFinishEvent:
+			dataPkg = &RecvTemplateSendJobFinishEventDataPkg{}
+		case MassSendJobFinishEvent:
+			dataPkg = &RecvMassSendJobFinishEventDataPkg{}
 		default:
 			return nil, fmt.Errorf("unknown event type: %s", probePkg.Event)
 		}
@@ -286,22 +422,10 @@ func (h *recvHandler) Parse(bodyText []byte, signature, timestamp, nonce, encryp
 // Response returns the response body data for the request from wechat mp platform.
 func (h *recvHandler) Response(msg []byte, encryptType string) ([]byte, error) {
 	if encryptType == "aes" {
-		msgEncrypt, err := EncryptMsg(msg, h.appID, h.encodingAESKey)
-		if err != nil {
-			return nil, err
-		}
-
-		nonce := pb.GenNonce()
-		timestamp := pb.GenTimestamp()
-		signature := pb.GenSignature(h.token, fmt.Sprintf("%d", timestamp), nonce, msgEncrypt)
-
-		resp := &RecvHTTPEncryptRespBody{
-			Encrypt:      pb.String2CDATA(msgEncrypt),
-			MsgSignature: pb.String2CDATA(signature),
-			TimeStamp:    timestamp,
-			Nonce:        pb.String2CDATA(nonce),
+		if h.envErr != nil {
+			return nil, h.envErr
 		}
-		return xml.MarshalIndent(resp, " ", "  ")
+		return h.env.Seal(msg)
 	}
 	return msg, nil
 }
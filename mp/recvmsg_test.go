@@ -1,9 +1,11 @@
 package mp_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/bigwhite/gowechat/mp"
+	"github.com/bigwhite/gowechat/pb"
 )
 
 func TestValidateSignatureOk(t *testing.T) {
@@ -27,3 +29,281 @@ func TestValidateSignatureFailed(t *testing.T) {
 		t.Error("want ValidateSignature return true, but actually it returns false")
 	}
 }
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte(textMsg), false)
+	f.Add([]byte(textMsg), true)
+	f.Add([]byte("0123456789abcdef\xff\xff\xff\xff"), true)
+	f.Add([]byte("<xml><MsgType>event</MsgType><Event>subscribe</Event></xml>"), false)
+
+	h := mp.NewRecvHandler(appID, serverToken, serverEncodingAESKey)
+	f.Fuzz(func(t *testing.T, data []byte, encrypted bool) {
+		timestamp, nonce := "1426139593", "1326298654"
+		signature := pb.GenSignature(serverToken, timestamp, nonce)
+		if !encrypted {
+			h.Parse(data, signature, "", timestamp, nonce, "")
+			return
+		}
+
+		// data is the decrypted payload, which is well encrypted.
+		msgEncrypt, err := pb.EncryptMsg(data, serverEncodingAESKey)
+		if err != nil {
+			t.Fatal("EncryptMsg error:", err)
+		}
+		body := "<xml><Encrypt><![CDATA[" + msgEncrypt + "]]></Encrypt></xml>"
+		msgSignature := pb.GenSignature(serverToken, timestamp, nonce, msgEncrypt)
+		h.Parse([]byte(body), signature, msgSignature, timestamp, nonce, "aes")
+
+		// data is the Encrypt field.
+		body = "<xml><Encrypt><![CDATA[" + string(data) + "]]></Encrypt></xml>"
+		msgSignature = pb.GenSignature(serverToken, timestamp, nonce, string(data))
+		h.Parse([]byte(body), signature, msgSignature, timestamp, nonce, "aes")
+	})
+}
+
+func TestParseMalformed(t *testing.T) {
+	h := mp.NewRecvHandler(appID, serverToken, serverEncodingAESKey)
+	timestamp, nonce := "1426139593", "1326298654"
+	signature := pb.GenSignature(serverToken, timestamp, nonce)
+
+	tests := []struct {
+		origData []byte
+		want     error
+	}{
+		{[]byte("0123456789abcdef\x00\x00"), pb.ErrBadLength},
+		{[]byte("0123456789abcdef\x7f\xff\xff\xffhello"), pb.ErrBadLength},
+		{append([]byte("0123456789abcdef\x00\x00\x00\x05hello"), "wx2f6d0a549c129f06"...), pb.ErrAppIDMismatch},
+	}
+	for _, tt := range tests {
+		msgEncrypt, err := pb.EncryptMsg(tt.origData, serverEncodingAESKey)
+		if err != nil {
+			t.Fatal("EncryptMsg error:", err)
+		}
+		body := "<xml><Encrypt><![CDATA[" + msgEncrypt + "]]></Encrypt></xml>"
+		msgSignature := pb.GenSignature(serverToken, timestamp, nonce, msgEncrypt)
+		if _, err := h.Parse([]byte(body), signature, msgSignature, timestamp, nonce, "aes"); !errors.Is(err, tt.want) {
+			t.Errorf("Parse %q: want[%v], but actually[%v]", tt.origData, tt.want, err)
+		}
+	}
+}
+
+func TestParseMsgSignature(t *testing.T) {
+	h := mp.NewRecvHandler(appID, serverToken, serverEncodingAESKey)
+	timestamp, nonce := "1426139593", "1326298654"
+	signature := pb.GenSignature(serverToken, timestamp, nonce)
+
+	msg := "<xml><ToUserName><![CDATA[toUser]]></ToUserName><MsgType><![CDATA[text]]></MsgType>" +
+		"<Content><![CDATA[hello]]></Content><MsgId>1234567890123456</MsgId></xml>"
+	msgEncrypt, err := mp.EncryptMsg([]byte(msg), appID, serverEncodingAESKey)
+	if err != nil {
+		t.Fatal("EncryptMsg error:", err)
+	}
+	body := "<xml><Encrypt><![CDATA[" + msgEncrypt + "]]></Encrypt></xml>"
+	msgSignature := pb.GenSignature(serverToken, timestamp, nonce, msgEncrypt)
+
+	tests := []struct {
+		name         string
+		msgSignature string
+		want         error
+	}{
+		{"valid msg_signature", msgSignature, nil},
+		{"invalid msg_signature", signature, pb.ErrInvalidSignature},
+		{"no msg_signature", "", pb.ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		if _, err := h.Parse([]byte(body), signature, tt.msgSignature, timestamp, nonce, "aes"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: want[%v], but actually[%v]", tt.name, tt.want, err)
+		}
+	}
+}
+
+// parseMsg parses the plaintext msg signed like wechat mp platform.
+func parseMsg(t *testing.T, msg string) interface{} {
+	h := mp.NewRecvHandler(appID, serverToken, serverEncodingAESKey)
+	timestamp, nonce := "1426139593", "1326298654"
+
+	signature := pb.GenSignature(serverToken, timestamp, nonce)
+	pkg, err := h.Parse([]byte(msg), signature, "", timestamp, nonce, "")
+	if err != nil {
+		t.Fatalf("Parse [%s] error: %s", msg, err)
+	}
+	return pkg
+}
+
+func TestParseTemplateSendJobFinishEvent(t *testing.T) {
+	msg := `<xml><ToUserName><![CDATA[gh_7f083739789a]]></ToUserName>
+<FromUserName><![CDATA[oia2TjuEGTNoeX76QEjQNrcURxG8]]></FromUserName>
+<CreateTime>1395658920</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[TEMPLATESENDJOBFINISH]]></Event>
+<MsgID>200163836</MsgID>
+<Status><![CDATA[failed:user block]]></Status>
+</xml>`
+
+	pkg, ok := parseMsg(t, msg).(*mp.RecvTemplateSendJobFinishEventDataPkg)
+	if !ok {
+		t.Fatalf("want *mp.RecvTemplateSendJobFinishEventDataPkg, but actually it is not")
+	}
+	if pkg.MsgID != 200163836 || pkg.Status != "failed:user block" {
+		t.Errorf("Event: want[200163836 failed:user block], but actually[%d %s]", pkg.MsgID, pkg.Status)
+	}
+	if key := pb.DedupeKey(pkg); key != "gh_7f083739789a:TEMPLATESENDJOBFINISH:200163836" {
+		t.Errorf("DedupeKey: want[gh_7f083739789a:TEMPLATESENDJOBFINISH:200163836], but actually[%s]", key)
+	}
+}
+
+func TestParseMassSendJobFinishEvent(t *testing.T) {
+	msg := `<xml><ToUserName><![CDATA[gh_4d00ed8d6399]]></ToUserName>
+<FromUserName><![CDATA[oV5CrjpxgaGXNHIQigzNlgLTnwic]]></FromUserName>
+<CreateTime>1481013459</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[MASSSENDJOBFINISH]]></Event>
+<MsgID>1000001625</MsgID>
+<Status><![CDATA[send success]]></Status>
+<TotalCount>100</TotalCount>
+<FilterCount>80</FilterCount>
+<SentCount>75</SentCount>
+<ErrorCount>5</ErrorCount>
+<CopyrightCheckResult>
+<Count>2</Count>
+<ResultList>
+<item>
+<ArticleIdx>1</ArticleIdx>
+<UserDeclareState>0</UserDeclareState>
+<AuditState>2</AuditState>
+<OriginalArticleUrl><![CDATA[Url_1]]></OriginalArticleUrl>
+<OriginalArticleType>1</OriginalArticleType>
+<CanReprint>1</CanReprint>
+<NeedReplaceContent>1</NeedReplaceContent>
+<NeedShowReprintSource>1</NeedShowReprintSource>
+</item>
+<item>
+<ArticleIdx>2</ArticleIdx>
+<UserDeclareState>0</UserDeclareState>
+<AuditState>2</AuditState>
+<OriginalArticleUrl><![CDATA[Url_2]]></OriginalArticleUrl>
+<OriginalArticleType>1</OriginalArticleType>
+<CanReprint>1</CanReprint>
+<NeedReplaceContent>1</NeedReplaceContent>
+<NeedShowReprintSource>1</NeedShowReprintSource>
+</item>
+</ResultList>
+<CheckState>2</CheckState>
+</CopyrightCheckResult>
+<ArticleUrlResult>
+<Count>1</Count>
+<ResultList>
+<item>
+<ArticleIdx>1</ArticleIdx>
+<ArticleUrl><![CDATA[http://mp.weixin.qq.com/s?__biz=MzI]]></ArticleUrl>
+</item>
+</ResultList>
+</ArticleUrlResult>
+</xml>`
+
+	pkg, ok := parseMsg(t, msg).(*mp.RecvMassSendJobFinishEventDataPkg)
+	if !ok {
+		t.Fatalf("want *mp.RecvMassSendJobFinishEventDataPkg, but actually it is not")
+	}
+	if pkg.MsgID != 1000001625 || pkg.Status != mp.MassSendSuccess {
+		t.Errorf("Event: want[1000001625 %s], but actually[%d %s]", mp.MassSendSuccess, pkg.MsgID, pkg.Status)
+	}
+	if pkg.TotalCount != 100 || pkg.FilterCount != 80 || pkg.SentCount != 75 || pkg.ErrorCount != 5 {
+		t.Errorf("Counts: want[100 80 75 5], but actually[%d %d %d %d]", pkg.TotalCount, pkg.FilterCount, pkg.SentCount, pkg.ErrorCount)
+	}
+	check := pkg.CopyrightCheckResult
+	if check.Count != 2 || check.CheckState != 2 || len(check.ResultList) != 2 {
+		t.Fatalf("CopyrightCheckResult: want[2 2 2 items], but actually[%d %d %d]", check.Count, check.CheckState, len(check.ResultList))
+	}
+	if item := check.ResultList[1]; item.ArticleIdx != 2 || item.AuditState != 2 || item.OriginalArticleURL != "Url_2" {
+		t.Errorf("ResultList: want[2 2 Url_2], but actually[%d %d %s]", item.ArticleIdx, item.AuditState, item.OriginalArticleURL)
+	}
+	urls := pkg.ArticleURLResult
+	if len(urls.ResultList) != 1 || urls.ResultList[0].ArticleURL != "http://mp.weixin.qq.com/s?__biz=MzI" {
+		t.Errorf("ArticleURLResult: want[http://mp.weixin.qq.com/s?__biz=MzI], but actually[%v]", urls.ResultList)
+	}
+}
+
+func TestParseMenuEvents(t *testing.T) {
+	scanMsg := `<xml><ToUserName><![CDATA[gh_e136c6e50636]]></ToUserName>
+<FromUserName><![CDATA[oMgHVjngRipVsoxg6TuX3vz6glDg]]></FromUserName>
+<CreateTime>1408090502</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[scancode_push]]></Event>
+<EventKey><![CDATA[6]]></EventKey>
+<ScanCodeInfo><ScanType><![CDATA[qrcode]]></ScanType>
+<ScanResult><![CDATA[1]]></ScanResult>
+</ScanCodeInfo>
+<MenuId>208379533</MenuId>
+</xml>`
+
+	scan, ok := parseMsg(t, scanMsg).(*mp.RecvScanCodeEventDataPkg)
+	if !ok {
+		t.Fatalf("want *mp.RecvScanCodeEventDataPkg, but actually it is not")
+	}
+	if scan.Event != mp.ScanCodePushEvent || scan.MenuID != "208379533" || scan.ScanCodeInfo.ScanResult != "1" {
+		t.Errorf("Scan: want[%s 208379533 1], but actually[%s %s %s]", mp.ScanCodePushEvent, scan.Event, scan.MenuID, scan.ScanCodeInfo.ScanResult)
+	}
+
+	picMsg := `<xml><ToUserName><![CDATA[gh_e136c6e50636]]></ToUserName>
+<FromUserName><![CDATA[oMgHVjngRipVsoxg6TuX3vz6glDg]]></FromUserName>
+<CreateTime>1408090651</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[pic_sysphoto]]></Event>
+<EventKey><![CDATA[6]]></EventKey>
+<SendPicsInfo><Count>1</Count>
+<PicList><item><PicMd5Sum><![CDATA[1b5f7c23b5bf75682a53e7b6d163e185]]></PicMd5Sum>
+</item>
+</PicList>
+</SendPicsInfo>
+</xml>`
+
+	pic, ok := parseMsg(t, picMsg).(*mp.RecvPicEventDataPkg)
+	if !ok {
+		t.Fatalf("want *mp.RecvPicEventDataPkg, but actually it is not")
+	}
+	if pic.SendPicsInfo.Count != 1 || len(pic.SendPicsInfo.PicList) != 1 ||
+		pic.SendPicsInfo.PicList[0].PicMd5Sum != "1b5f7c23b5bf75682a53e7b6d163e185" {
+		t.Errorf("SendPicsInfo: want[1 1b5f7c23b5bf75682a53e7b6d163e185], but actually[%v]", pic.SendPicsInfo)
+	}
+
+	locationMsg := `<xml><ToUserName><![CDATA[gh_e136c6e50636]]></ToUserName>
+<FromUserName><![CDATA[oMgHVjngRipVsoxg6TuX3vz6glDg]]></FromUserName>
+<CreateTime>1408091189</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[location_select]]></Event>
+<EventKey><![CDATA[6]]></EventKey>
+<SendLocationInfo><Location_X><![CDATA[23]]></Location_X>
+<Location_Y><![CDATA[113]]></Location_Y>
+<Scale><![CDATA[15]]></Scale>
+<Label><![CDATA[ 广州市海珠区客村艺苑路 106号]]></Label>
+<Poiname><![CDATA[]]></Poiname>
+</SendLocationInfo>
+</xml>`
+
+	location, ok := parseMsg(t, locationMsg).(*mp.RecvLocationSelectEventDataPkg)
+	if !ok {
+		t.Fatalf("want *mp.RecvLocationSelectEventDataPkg, but actually it is not")
+	}
+	if info := location.SendLocationInfo; info.LocX != 23 || info.LocY != 113 || info.Scale != 15 {
+		t.Errorf("SendLocationInfo: want[23 113 15], but actually[%v %v %d]", info.LocX, info.LocY, info.Scale)
+	}
+
+	miniprogramMsg := `<xml><ToUserName><![CDATA[gh_e136c6e50636]]></ToUserName>
+<FromUserName><![CDATA[oMgHVjngRipVsoxg6TuX3vz6glDg]]></FromUserName>
+<CreateTime>1408091189</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[view_miniprogram]]></Event>
+<EventKey><![CDATA[pages/index/index]]></EventKey>
+<MenuId>MENUID</MenuId>
+</xml>`
+
+	menu, ok := parseMsg(t, miniprogramMsg).(*mp.RecvMenuEventDataPkg)
+	if !ok {
+		t.Fatalf("want *mp.RecvMenuEventDataPkg, but actually it is not")
+	}
+	if menu.Event != mp.MenuViewMiniprogramEvent || menu.EventKey != "pages/index/index" || menu.MenuID != "MENUID" {
+		t.Errorf("Menu: want[%s pages/index/index MENUID], but actually[%s %s %s]",
+			mp.MenuViewMiniprogramEvent, menu.Event, menu.EventKey, menu.MenuID)
+	}
+}
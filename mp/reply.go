@@ -0,0 +1,215 @@
+// Package mp provides the passive reply messages.
+package mp
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+const (
+	// Reply msg type, besides text, image, voice and video.
+	MusicMsg = "music"
+	NewsMsg  = "news"
+)
+
+// RecvRespTextDataPkg is a Text reply to the received message.
+type RecvRespTextDataPkg struct {
+	pb.RecvRespBaseDataPkg
+	Content pb.CDATAText
+}
+
+// RespMedia is the media of an image or voice reply.
+type RespMedia = pb.RespMedia
+
+// RecvRespImageDataPkg is a Image reply to the received message.
+type RecvRespImageDataPkg struct {
+	pb.RecvRespBaseDataPkg
+	Image RespMedia
+}
+
+// RecvRespVoiceDataPkg is a Voice reply to the received message.
+type RecvRespVoiceDataPkg struct {
+	pb.RecvRespBaseDataPkg
+	Voice RespMedia
+}
+
+// RespVideo is the video of a video reply.
+type RespVideo = pb.RespVideo
+
+// RecvRespVideoDataPkg is a Video reply to the received message.
+type RecvRespVideoDataPkg struct {
+	pb.RecvRespBaseDataPkg
+	Video RespVideo
+}
+
+// RespMusic is the music of a music reply.
+type RespMusic struct {
+	Title        pb.CDATAText
+	Description  pb.CDATAText
+	MusicURL     pb.CDATAText `xml:"MusicUrl"`
+	HQMusicURL   pb.CDATAText `xml:"HQMusicUrl"`
+	ThumbMediaID pb.CDATAText `xml:"ThumbMediaId"`
+}
+
+// RecvRespMusicDataPkg is a Music reply to the received message.
+type RecvRespMusicDataPkg struct {
+	pb.RecvRespBaseDataPkg
+	Music RespMusic
+}
+
+// Article is an article of a news reply or a news custom service message.
+type Article struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	PicURL      string `json:"picurl,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// RespArticle is an article in a news reply.
+type RespArticle = pb.RespArticle
+
+// RecvRespNewsDataPkg is a News reply to the received message.
+type RecvRespNewsDataPkg = pb.RecvRespNewsDataPkg
+
+// NewRecvRespTextDataPkg creates a Text reply to recv.
+func NewRecvRespTextDataPkg(recv pb.RecvPkg, content string) *RecvRespTextDataPkg {
+	return &RecvRespTextDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, TextMsg),
+		Content:             pb.String2CDATA(content),
+	}
+}
+
+// NewRecvRespImageDataPkg creates a Image reply to recv with the image
+// uploaded as mediaID.
+func NewRecvRespImageDataPkg(recv pb.RecvPkg, mediaID string) *RecvRespImageDataPkg {
+	return &RecvRespImageDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, ImageMsg),
+		Image:               RespMedia{MediaID: pb.String2CDATA(mediaID)},
+	}
+}
+
+// NewRecvRespVoiceDataPkg creates a Voice reply to recv with the voice
+// uploaded as mediaID.
+func NewRecvRespVoiceDataPkg(recv pb.RecvPkg, mediaID string) *RecvRespVoiceDataPkg {
+	return &RecvRespVoiceDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, VoiceMsg),
+		Voice:               RespMedia{MediaID: pb.String2CDATA(mediaID)},
+	}
+}
+
+// NewRecvRespVideoDataPkg creates a Video reply to recv with the video
+// uploaded as mediaID.
+func NewRecvRespVideoDataPkg(recv pb.RecvPkg, mediaID, title, description string) *RecvRespVideoDataPkg {
+	return &RecvRespVideoDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, VideoMsg),
+		Video: RespVideo{
+			MediaID:     pb.String2CDATA(mediaID),
+			Title:       pb.String2CDATA(title),
+			Description: pb.String2CDATA(description),
+		},
+	}
+}
+
+// NewRecvRespMusicDataPkg creates a Music reply to recv. The thumb image
+// is uploaded as thumbMediaID.
+func NewRecvRespMusicDataPkg(recv pb.RecvPkg, title, description, musicURL, hqMusicURL, thumbMediaID string) *RecvRespMusicDataPkg {
+	return &RecvRespMusicDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, MusicMsg),
+		Music: RespMusic{
+			Title:        pb.String2CDATA(title),
+			Description:  pb.String2CDATA(description),
+			MusicURL:     pb.String2CDATA(musicURL),
+			HQMusicURL:   pb.String2CDATA(hqMusicURL),
+			ThumbMediaID: pb.String2CDATA(thumbMediaID),
+		},
+	}
+}
+
+// NewRecvRespNewsDataPkg creates a News reply to recv with articles.
+func NewRecvRespNewsDataPkg(recv pb.RecvPkg, articles ...Article) *RecvRespNewsDataPkg {
+	pkg := &RecvRespNewsDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, NewsMsg),
+		ArticleCount:        len(articles),
+		Articles:            make([]RespArticle, 0, len(articles)),
+	}
+	for _, a := range articles {
+		pkg.Articles = append(pkg.Articles, RespArticle{
+			Title:       pb.String2CDATA(a.Title),
+			Description: pb.String2CDATA(a.Description),
+			PicURL:      pb.String2CDATA(a.PicURL),
+			URL:         pb.String2CDATA(a.URL),
+		})
+	}
+	return pkg
+}
+
+// DeliverReply sends reply to the sender of the received message pkg as a
+// custom service message. It is a pb.DeliverFunc for the async mode of the
+// callback server. The replies created by NewRecvRespXxxDataPkg, except
+// video which has no thumb_media_id required by the custom service message,
+// are converted to the custom service messages, and the others are sent as
+// is.
+func (c *Client) DeliverReply(ctx context.Context, pkg interface{}, reply pb.Reply) error {
+	msg, err := customMsg(reply)
+	if err != nil {
+		return err
+	}
+	return c.SendMsgContext(ctx, msg)
+}
+
+// customMsg converts reply to the custom service message.
+func customMsg(reply pb.Reply) (interface{}, error) {
+	switch r := reply.(type) {
+	case *RecvRespTextDataPkg:
+		return &pb.SendMsgTextPkg{
+			ToUser:  pb.CDATA2String(r.ToUserName),
+			MsgType: TextMsg,
+			Text:    pb.TextContent{Content: pb.CDATA2String(r.Content)},
+		}, nil
+	case *RecvRespImageDataPkg:
+		return &pb.SendMsgImagePkg{
+			ToUser:  pb.CDATA2String(r.ToUserName),
+			MsgType: ImageMsg,
+			Image:   pb.MediaID{MediaID: pb.CDATA2String(r.Image.MediaID)},
+		}, nil
+	case *RecvRespVoiceDataPkg:
+		return &SendMsgVoicePkg{
+			ToUser:  pb.CDATA2String(r.ToUserName),
+			MsgType: VoiceMsg,
+			Voice:   pb.MediaID{MediaID: pb.CDATA2String(r.Voice.MediaID)},
+		}, nil
+	case *RecvRespVideoDataPkg:
+		return nil, errors.New("the video reply could not be delivered without thumb_media_id")
+	case *RecvRespMusicDataPkg:
+		return &SendMsgMusicPkg{
+			ToUser:  pb.CDATA2String(r.ToUserName),
+			MsgType: MusicMsg,
+			Music: MusicContent{
+				Title:        pb.CDATA2String(r.Music.Title),
+				Description:  pb.CDATA2String(r.Music.Description),
+				MusicURL:     pb.CDATA2String(r.Music.MusicURL),
+				HQMusicURL:   pb.CDATA2String(r.Music.HQMusicURL),
+				ThumbMediaID: pb.CDATA2String(r.Music.ThumbMediaID),
+			},
+		}, nil
+	case *RecvRespNewsDataPkg:
+		msg := &SendMsgNewsPkg{
+			ToUser:  pb.CDATA2String(r.ToUserName),
+			MsgType: NewsMsg,
+		}
+		for _, a := range r.Articles {
+			msg.News.Articles = append(msg.News.Articles, Article{
+				Title:       pb.CDATA2String(a.Title),
+				Description: pb.CDATA2String(a.Description),
+				PicURL:      pb.CDATA2String(a.PicURL),
+				URL:         pb.CDATA2String(a.URL),
+			})
+		}
+		return msg, nil
+	case []byte:
+		return nil, errors.New("the marshalled reply could not be delivered")
+	}
+	return reply, nil
+}
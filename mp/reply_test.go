@@ -0,0 +1,135 @@
+package mp_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/bigwhite/gowechat/mp"
+	"github.com/bigwhite/gowechat/pb"
+)
+
+var recvText = &mp.RecvTextDataPkg{
+	RecvBaseDataPkg: pb.RecvBaseDataPkg{
+		ToUserName:   "gh_0123456789ab",
+		FromUserName: "oUser",
+		CreateTime:   1348831860,
+		MsgType:      mp.TextMsg,
+	},
+	Content: "hello",
+}
+
+func marshalReply(t *testing.T, reply interface{}) string {
+	data, err := xml.MarshalIndent(reply, "", "  ")
+	if err != nil {
+		t.Fatal("Xml marshalling error:", err)
+	}
+	return string(data)
+}
+
+func TestNewRecvRespTextDataPkg(t *testing.T) {
+	pkg := mp.NewRecvRespTextDataPkg(recvText, "hello, world")
+	if pkg.CreateTime == 0 {
+		t.Error("CreateTime: want non-zero, but actually 0")
+	}
+	pkg.CreateTime = 1348831860
+
+	want := `<xml>
+  <ToUserName><![CDATA[oUser]]></ToUserName>
+  <FromUserName><![CDATA[gh_0123456789ab]]></FromUserName>
+  <CreateTime>1348831860</CreateTime>
+  <MsgType><![CDATA[text]]></MsgType>
+  <Content><![CDATA[hello, world]]></Content>
+</xml>`
+	if got := marshalReply(t, pkg); got != want {
+		t.Errorf("Want [%s], but actual[%s]", want, got)
+	}
+}
+
+func TestNewRecvRespTextDataPkgInjection(t *testing.T) {
+	content := "hi]]></Content><MsgType><![CDATA[news]]></MsgType><Content><![CDATA["
+	data := marshalReply(t, mp.NewRecvRespTextDataPkg(recvText, content))
+
+	got := &struct {
+		MsgType []string
+		Content []string
+	}{}
+	if err := xml.Unmarshal([]byte(data), got); err != nil {
+		t.Fatalf("Xml decoding [%s] error: %s", data, err)
+	}
+	if len(got.MsgType) != 1 || got.MsgType[0] != mp.TextMsg {
+		t.Errorf("MsgType: want[[%s]], but actually%v", mp.TextMsg, got.MsgType)
+	}
+	if len(got.Content) != 1 || got.Content[0] != content {
+		t.Errorf("Content: want[[%s]], but actually%v", content, got.Content)
+	}
+}
+
+func TestNewRecvRespMediaDataPkg(t *testing.T) {
+	tests := []struct {
+		reply interface{}
+		want  string
+	}{
+		{mp.NewRecvRespImageDataPkg(recvText, "media1"),
+			`<MsgType><![CDATA[image]]></MsgType><Image><MediaId><![CDATA[media1]]></MediaId></Image>`},
+		{mp.NewRecvRespVoiceDataPkg(recvText, "media2"),
+			`<MsgType><![CDATA[voice]]></MsgType><Voice><MediaId><![CDATA[media2]]></MediaId></Voice>`},
+		{mp.NewRecvRespVideoDataPkg(recvText, "media3", "title", "desc"),
+			`<MsgType><![CDATA[video]]></MsgType><Video><MediaId><![CDATA[media3]]></MediaId>` +
+				`<Title><![CDATA[title]]></Title><Description><![CDATA[desc]]></Description></Video>`},
+		{mp.NewRecvRespMusicDataPkg(recvText, "title", "desc", "http://m.qq.com/a.mp3", "http://m.qq.com/a-hq.mp3", "thumb1"),
+			`<MsgType><![CDATA[music]]></MsgType><Music><Title><![CDATA[title]]></Title>` +
+				`<Description><![CDATA[desc]]></Description><MusicUrl><![CDATA[http://m.qq.com/a.mp3]]></MusicUrl>` +
+				`<HQMusicUrl><![CDATA[http://m.qq.com/a-hq.mp3]]></HQMusicUrl><ThumbMediaId><![CDATA[thumb1]]></ThumbMediaId></Music>`},
+	}
+	for _, tt := range tests {
+		data, err := xml.Marshal(tt.reply)
+		if err != nil {
+			t.Fatal("Xml marshalling error:", err)
+		}
+		if got := string(data); !strings.Contains(got, tt.want) {
+			t.Errorf("Reply of %T: want[%s] in it, but actually[%s]", tt.reply, tt.want, got)
+		}
+	}
+}
+
+func TestNewRecvRespNewsDataPkg(t *testing.T) {
+	pkg := mp.NewRecvRespNewsDataPkg(recvText,
+		mp.Article{Title: "title1", Description: "desc1", PicURL: "http://p.qq.com/1.jpg", URL: "http://www.qq.com/1"},
+		mp.Article{Title: "title2", URL: "http://www.qq.com/2"})
+	pkg.CreateTime = 1348831860
+
+	want := `<xml>
+  <ToUserName><![CDATA[oUser]]></ToUserName>
+  <FromUserName><![CDATA[gh_0123456789ab]]></FromUserName>
+  <CreateTime>1348831860</CreateTime>
+  <MsgType><![CDATA[news]]></MsgType>
+  <ArticleCount>2</ArticleCount>
+  <Articles>
+    <item>
+      <Title><![CDATA[title1]]></Title>
+      <Description><![CDATA[desc1]]></Description>
+      <PicUrl><![CDATA[http://p.qq.com/1.jpg]]></PicUrl>
+      <Url><![CDATA[http://www.qq.com/1]]></Url>
+    </item>
+    <item>
+      <Title><![CDATA[title2]]></Title>
+      <Description><![CDATA[]]></Description>
+      <PicUrl><![CDATA[]]></PicUrl>
+      <Url><![CDATA[http://www.qq.com/2]]></Url>
+    </item>
+  </Articles>
+</xml>`
+	if got := marshalReply(t, pkg); got != want {
+		t.Errorf("Want [%s], but actual[%s]", want, got)
+	}
+}
+
+func TestClientDeliverReplyVideo(t *testing.T) {
+	c := mp.NewClient(appID, appSecret)
+	reply := mp.NewRecvRespVideoDataPkg(recvText, "media3", "title", "desc")
+	if err := c.DeliverReply(context.Background(), recvText, reply); err == nil {
+		t.Error("DeliverReply of video: want an error, but actually nil")
+	}
+}
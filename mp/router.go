@@ -0,0 +1,162 @@
+// Package mp provides the message router for wechat mp dev.
+package mp
+
+import (
+	"context"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+// Router dispatches the packages parsed by the RecvHandler of NewRecvHandler
+// to the typed handlers registered on it, see pb.Router for the order.
+// It could be passed to NewServer as the handler.
+type Router struct {
+	*pb.Router
+}
+
+// NewRouter creates an empty Router.
+func NewRouter() *Router {
+	return &Router{pb.NewRouter()}
+}
+
+// OnText registers h for text messages.
+func (r *Router) OnText(h func(ctx context.Context, pkg *RecvTextDataPkg) pb.Reply) {
+	r.HandleType((*RecvTextDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvTextDataPkg))
+	}))
+}
+
+// OnImage registers h for image messages.
+func (r *Router) OnImage(h func(ctx context.Context, pkg *RecvImageDataPkg) pb.Reply) {
+	r.HandleType((*RecvImageDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvImageDataPkg))
+	}))
+}
+
+// OnVoice registers h for voice messages without recognition.
+func (r *Router) OnVoice(h func(ctx context.Context, pkg *RecvVoiceDataPkg) pb.Reply) {
+	r.HandleType((*RecvVoiceDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvVoiceDataPkg))
+	}))
+}
+
+// OnVoiceRecognition registers h for voice messages with recognition.
+func (r *Router) OnVoiceRecognition(h func(ctx context.Context, pkg *RecvVoiceRecognitionDataPkg) pb.Reply) {
+	r.HandleType((*RecvVoiceRecognitionDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvVoiceRecognitionDataPkg))
+	}))
+}
+
+// OnVideo registers h for video messages.
+func (r *Router) OnVideo(h func(ctx context.Context, pkg *RecvVideoDataPkg) pb.Reply) {
+	r.HandleType((*RecvVideoDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvVideoDataPkg))
+	}))
+}
+
+// OnShortVideo registers h for short video messages.
+func (r *Router) OnShortVideo(h func(ctx context.Context, pkg *RecvShortVideoDataPkg) pb.Reply) {
+	r.HandleType((*RecvShortVideoDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvShortVideoDataPkg))
+	}))
+}
+
+// OnLocation registers h for location messages.
+func (r *Router) OnLocation(h func(ctx context.Context, pkg *RecvLocationDataPkg) pb.Reply) {
+	r.HandleType((*RecvLocationDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvLocationDataPkg))
+	}))
+}
+
+// OnLink registers h for link messages.
+func (r *Router) OnLink(h func(ctx context.Context, pkg *RecvLinkDataPkg) pb.Reply) {
+	r.HandleType((*RecvLinkDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvLinkDataPkg))
+	}))
+}
+
+// OnSubscribeEvent registers h for subscribe and unsubscribe events.
+func (r *Router) OnSubscribeEvent(h func(ctx context.Context, pkg *RecvSubscribeEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvSubscribeEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvSubscribeEventDataPkg))
+	}))
+}
+
+// OnUnsubscribeScanEvent registers h for subscribe events by scanning a qrcode.
+func (r *Router) OnUnsubscribeScanEvent(h func(ctx context.Context, pkg *RecvUnsubscribeScanEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvUnsubscribeScanEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvUnsubscribeScanEventDataPkg))
+	}))
+}
+
+// OnScanEvent registers h for qrcode scan events of subscribed users.
+func (r *Router) OnScanEvent(h func(ctx context.Context, pkg *RecvScanEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvScanEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvScanEventDataPkg))
+	}))
+}
+
+// OnLocationEvent registers h for location report events.
+func (r *Router) OnLocationEvent(h func(ctx context.Context, pkg *RecvLocationEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvLocationEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvLocationEventDataPkg))
+	}))
+}
+
+// OnMenuEvent registers h for menu click, view and view_miniprogram events.
+func (r *Router) OnMenuEvent(h func(ctx context.Context, pkg *RecvMenuEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvMenuEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvMenuEventDataPkg))
+	}))
+}
+
+// OnScanCodeEvent registers h for scancode_push and scancode_waitmsg menu
+// events.
+func (r *Router) OnScanCodeEvent(h func(ctx context.Context, pkg *RecvScanCodeEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvScanCodeEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvScanCodeEventDataPkg))
+	}))
+}
+
+// OnPicEvent registers h for pic_sysphoto, pic_photo_or_album and
+// pic_weixin menu events.
+func (r *Router) OnPicEvent(h func(ctx context.Context, pkg *RecvPicEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvPicEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvPicEventDataPkg))
+	}))
+}
+
+// OnLocationSelectEvent registers h for location_select menu events.
+func (r *Router) OnLocationSelectEvent(h func(ctx context.Context, pkg *RecvLocationSelectEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvLocationSelectEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvLocationSelectEventDataPkg))
+	}))
+}
+
+// OnTemplateSendJobFinishEvent registers h for template message send job
+// finish events.
+func (r *Router) OnTemplateSendJobFinishEvent(h func(ctx context.Context, pkg *RecvTemplateSendJobFinishEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvTemplateSendJobFinishEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvTemplateSendJobFinishEventDataPkg))
+	}))
+}
+
+// OnMassSendJobFinishEvent registers h for mass message send job finish
+// events.
+func (r *Router) OnMassSendJobFinishEvent(h func(ctx context.Context, pkg *RecvMassSendJobFinishEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvMassSendJobFinishEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvMassSendJobFinishEventDataPkg))
+	}))
+}
+
+// OnMenuKey registers h for the click, view and view_miniprogram events of
+// the menu item with key. The key of a view menu item is its url, and the
+// one of a view_miniprogram menu item is the page path.
+func (r *Router) OnMenuKey(key string, h func(ctx context.Context, pkg *RecvMenuEventDataPkg) pb.Reply) {
+	handler := pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvMenuEventDataPkg))
+	})
+	r.HandleEventKey(MenuClickEvent, key, handler)
+	r.HandleEventKey(MenuViewEvent, key, handler)
+	r.HandleEventKey(MenuViewMiniprogramEvent, key, handler)
+}
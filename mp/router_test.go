@@ -0,0 +1,59 @@
+package mp_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bigwhite/gowechat/mp"
+	"github.com/bigwhite/gowechat/pb"
+)
+
+func TestRouter(t *testing.T) {
+	r := mp.NewRouter()
+	r.OnText(func(ctx context.Context, pkg *mp.RecvTextDataPkg) pb.Reply {
+		return "text:" + pkg.Content
+	})
+	r.OnMenuKey("s1-item1", func(ctx context.Context, pkg *mp.RecvMenuEventDataPkg) pb.Reply {
+		return "menu:" + pkg.EventKey
+	})
+	r.OnMenuKey("pages/index/index", func(ctx context.Context, pkg *mp.RecvMenuEventDataPkg) pb.Reply {
+		return "miniprogram:" + pkg.EventKey
+	})
+	r.OnEvent(mp.MenuClickEvent, func(ctx context.Context, pkg interface{}) pb.Reply {
+		return "click"
+	})
+	r.Default(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return "default"
+	})
+
+	tests := []struct {
+		pkg  interface{}
+		want pb.Reply
+	}{
+		{&mp.RecvTextDataPkg{Content: "hello body"}, "text:hello body"},
+		{&mp.RecvMenuEventDataPkg{Event: mp.MenuClickEvent, EventKey: "s1-item1"}, "menu:s1-item1"},
+		{&mp.RecvMenuEventDataPkg{Event: mp.MenuClickEvent, EventKey: "s1-item2"}, "click"},
+		{&mp.RecvMenuEventDataPkg{Event: mp.MenuViewEvent, EventKey: "http://www.qq.com"}, "default"},
+		{&mp.RecvMenuEventDataPkg{Event: mp.MenuViewMiniprogramEvent, EventKey: "pages/index/index"}, "miniprogram:pages/index/index"},
+		{&mp.RecvImageDataPkg{}, "default"},
+	}
+	for _, tt := range tests {
+		if got := r.ServeMsg(context.Background(), tt.pkg); got != tt.want {
+			t.Errorf("Reply of %T: want[%v], but actually[%v]", tt.pkg, tt.want, got)
+		}
+	}
+}
+
+func TestRouterNoDefault(t *testing.T) {
+	r := mp.NewRouter()
+	r.OnVoiceRecognition(func(ctx context.Context, pkg *mp.RecvVoiceRecognitionDataPkg) pb.Reply {
+		return pkg.Recognition
+	})
+
+	if got := r.ServeMsg(context.Background(), &mp.RecvVoiceRecognitionDataPkg{Recognition: "hello"}); got != "hello" {
+		t.Errorf("Reply: want[%s], but actually[%v]", "hello", got)
+	}
+	if got := r.ServeMsg(context.Background(), &mp.RecvVoiceDataPkg{}); got != nil {
+		t.Errorf("Reply: want[nil], but actually[%v]", got)
+	}
+}
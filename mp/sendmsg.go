@@ -1,16 +1,79 @@
 package mp
 
 import (
-	"strings"
+	"context"
+	"net/url"
 
 	"github.com/bigwhite/gowechat/pb"
 )
 
-const (
-	sendURL = "https://api.weixin.qq.com/cgi-bin/message/custom/send"
-)
-
 func SendMsg(accessToken string, pkg interface{}) error {
-	r := strings.Join([]string{sendURL, "?access_token=", accessToken}, "")
-	return pb.SendMsg(r, pkg)
+	return SendMsgContext(context.Background(), accessToken, pkg)
+}
+
+// SendMsgContext is like SendMsg with a context.
+func SendMsgContext(ctx context.Context, accessToken string, pkg interface{}) error {
+	query := url.Values{}
+	query.Set("access_token", accessToken)
+	return pb.SendMsgContext(ctx, pb.NewClient(DefaultBaseURL), sendPath, query, pkg)
+}
+
+// SendMsg sends pkg as a custom service message with the access token of c.
+func (c *Client) SendMsg(pkg interface{}) error {
+	return c.SendMsgContext(context.Background(), pkg)
+}
+
+// SendMsgContext is like SendMsg with a context.
+func (c *Client) SendMsgContext(ctx context.Context, pkg interface{}) error {
+	return pb.SendMsgContext(ctx, c.Client, sendPath, nil, pkg)
+}
+
+// SendMsgVoicePkg is a Voice custom service message.
+type SendMsgVoicePkg struct {
+	ToUser  string     `json:"touser"`
+	MsgType string     `json:"msgtype"`
+	Voice   pb.MediaID `json:"voice"`
+}
+
+// VideoContent is the video of a Video custom service message.
+type VideoContent struct {
+	MediaID      string `json:"media_id"`
+	ThumbMediaID string `json:"thumb_media_id"`
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// SendMsgVideoPkg is a Video custom service message.
+type SendMsgVideoPkg struct {
+	ToUser  string       `json:"touser"`
+	MsgType string       `json:"msgtype"`
+	Video   VideoContent `json:"video"`
+}
+
+// MusicContent is the music of a Music custom service message.
+type MusicContent struct {
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	MusicURL     string `json:"musicurl"`
+	HQMusicURL   string `json:"hqmusicurl"`
+	ThumbMediaID string `json:"thumb_media_id"`
+}
+
+// SendMsgMusicPkg is a Music custom service message.
+type SendMsgMusicPkg struct {
+	ToUser  string       `json:"touser"`
+	MsgType string       `json:"msgtype"`
+	Music   MusicContent `json:"music"`
+}
+
+// Articles is the articles of a News custom service message.
+type Articles struct {
+	Articles []Article `json:"articles"`
+}
+
+// SendMsgNewsPkg is a News custom service message.
+type SendMsgNewsPkg struct {
+	ToUser  string   `json:"touser"`
+	MsgType string   `json:"msgtype"`
+	News    Articles `json:"news"`
 }
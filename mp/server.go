@@ -0,0 +1,34 @@
+// Package mp provides the callback server for wechat mp dev.
+package mp
+
+import (
+	"net/url"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+// NewServer creates an http.Handler serving the callback url of app appID.
+// It validates the url verification requests with ValidateSignature, parses
+// the messages with the RecvHandler of NewRecvHandler, and responds with the
+// replies of handler, encrypted if the request is. pb.WithReplayGuard in
+// opts sets the Guard of the Server, which guards the url verification
+// requests too.
+func NewServer(appID, token, encodingAESKey string, handler pb.MsgHandler, opts ...pb.RecvOption) *pb.Server {
+	// The Server checks the nonces after Dedupe, so that the retries of
+	// wechat platform are answered with the cached replies.
+	guard := pb.NewRecvOptions(opts...).Guard.WithScope(appID)
+	verify := func(query url.Values) ([]byte, bool) {
+		timestamp, nonce := query.Get("timestamp"), query.Get("nonce")
+		if !ValidateSignature(query.Get("signature"), token, timestamp, nonce) {
+			return nil, false
+		}
+		if guard != nil && guard.Check(timestamp, nonce) != nil {
+			return nil, false
+		}
+		return []byte(query.Get("echostr")), true
+	}
+
+	s := pb.NewServer(NewRecvHandler(appID, token, encodingAESKey), verify, handler)
+	s.Guard = guard
+	return s
+}
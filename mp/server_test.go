@@ -0,0 +1,515 @@
+package mp_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bigwhite/gowechat/mp"
+	"github.com/bigwhite/gowechat/pb"
+)
+
+const (
+	serverToken          = "wechat4go"
+	serverEncodingAESKey = "jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2C"
+	textMsg              = `<xml>
+<ToUserName><![CDATA[gh_1234567890ab]]></ToUserName>
+<FromUserName><![CDATA[oDF3iY9ffA-hqb2vVvbr7qxf6A0Q]]></FromUserName>
+<CreateTime>1426139593</CreateTime>
+<MsgType><![CDATA[text]]></MsgType>
+<Content><![CDATA[hello body]]></Content>
+<MsgId>1234567890123456</MsgId>
+</xml>`
+	replyMsg = `<xml><Content><![CDATA[hello back]]></Content></xml>`
+)
+
+// echoHandler replies replyMsg to a text message "hello body", and no
+// reply to the others.
+var echoHandler = pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+	if text, ok := pkg.(*mp.RecvTextDataPkg); ok && text.Content == "hello body" {
+		return []byte(replyMsg)
+	}
+	return nil
+})
+
+// signedQuery returns the query of a callback request signed with serverToken.
+func signedQuery(timestamp, nonce string) url.Values {
+	query := url.Values{}
+	query.Set("timestamp", timestamp)
+	query.Set("nonce", nonce)
+	query.Set("signature", pb.GenSignature(serverToken, timestamp, nonce))
+	return query
+}
+
+func TestServerVerifyURL(t *testing.T) {
+	s := mp.NewServer(appID, serverToken, serverEncodingAESKey, echoHandler)
+
+	query := url.Values{}
+	query.Set("signature", "78d6123977c8e5ecb255b74ecef385c5a1b5823f")
+	query.Set("timestamp", "1426139593")
+	query.Set("nonce", "1326298654")
+	query.Set("echostr", "4362985891886127916")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/callback?"+query.Encode(), nil))
+	if w.Code != http.StatusOK || w.Body.String() != "4362985891886127916" {
+		t.Errorf("Response: want[200 %s], but actually[%d %s]", "4362985891886127916", w.Code, w.Body.String())
+	}
+
+	query.Set("signature", "78d6123977c8e5ecb255b74ecef385c5a1b5823e")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/callback?"+query.Encode(), nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Status: want[%d], but actually[%d]", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestServerPlainMsg(t *testing.T) {
+	s := mp.NewServer(appID, serverToken, serverEncodingAESKey, echoHandler)
+	s.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	query := signedQuery("1426139593", "1326298654")
+	resp, err := http.Post(ts.URL+"?"+query.Encode(), "text/xml", strings.NewReader(textMsg))
+	if err != nil {
+		t.Fatal("Post error:", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != replyMsg {
+		t.Errorf("Reply: want[%s], but actually[%s]", replyMsg, body)
+	}
+
+	// No reply.
+	msg := strings.Replace(textMsg, "hello body", "bye", 1)
+	resp, err = http.Post(ts.URL+"?"+query.Encode(), "text/xml", strings.NewReader(msg))
+	if err != nil {
+		t.Fatal("Post error:", err)
+	}
+	defer resp.Body.Close()
+	body, _ = ioutil.ReadAll(resp.Body)
+	if string(body) != "success" {
+		t.Errorf("Reply: want[%s], but actually[%s]", "success", body)
+	}
+
+	// Bad signature.
+	query.Set("signature", "78d6123977c8e5ecb255b74ecef385c5a1b5823e")
+	resp, err = http.Post(ts.URL+"?"+query.Encode(), "text/xml", strings.NewReader(textMsg))
+	if err != nil {
+		t.Fatal("Post error:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Status: want[%d], but actually[%d]", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestServerAESMsg(t *testing.T) {
+	ts := httptest.NewServer(mp.NewServer(appID, serverToken, serverEncodingAESKey, echoHandler))
+	defer ts.Close()
+
+	msgEncrypt, err := mp.EncryptMsg([]byte(textMsg), appID, serverEncodingAESKey)
+	if err != nil {
+		t.Fatal("EncryptMsg error:", err)
+	}
+	reqBody := "<xml><ToUserName><![CDATA[gh_1234567890ab]]></ToUserName><Encrypt><![CDATA[" +
+		msgEncrypt + "]]></Encrypt></xml>"
+
+	query := signedQuery("1426139593", "1326298654")
+	query.Set("encrypt_type", "aes")
+	query.Set("msg_signature", pb.GenSignature(serverToken, "1426139593", "1326298654", msgEncrypt))
+	resp, err := http.Post(ts.URL+"?"+query.Encode(), "text/xml", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatal("Post error:", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	respBody := &struct {
+		Encrypt      string
+		MsgSignature string
+		TimeStamp    string
+		Nonce        string
+	}{}
+	if err = xml.Unmarshal(body, respBody); err != nil {
+		t.Fatalf("Xml decoding [%s] error: %s", body, err)
+	}
+	signature := pb.GenSignature(serverToken, respBody.TimeStamp, respBody.Nonce, respBody.Encrypt)
+	if respBody.MsgSignature != signature {
+		t.Errorf("MsgSignature: want[%s], but actually[%s]", signature, respBody.MsgSignature)
+	}
+
+	msg, _, appIDDecrypted, err := mp.DecryptMsg(respBody.Encrypt, serverEncodingAESKey)
+	if err != nil {
+		t.Fatal("DecryptMsg error:", err)
+	}
+	if string(msg) != replyMsg || appIDDecrypted != appID {
+		t.Errorf("Reply: want[%s %s], but actually[%s %s]", replyMsg, appID, msg, appIDDecrypted)
+	}
+}
+
+func TestServerCompatibleMsg(t *testing.T) {
+	ts := httptest.NewServer(mp.NewServer(appID, serverToken, serverEncodingAESKey, echoHandler))
+	defer ts.Close()
+
+	msgEncrypt, err := mp.EncryptMsg([]byte(textMsg), appID, serverEncodingAESKey)
+	if err != nil {
+		t.Fatal("EncryptMsg error:", err)
+	}
+	// The body of the compatible mode has the plaintext fields besides Encrypt.
+	reqBody := strings.Replace(textMsg, "</xml>", "<Encrypt><![CDATA["+msgEncrypt+"]]></Encrypt></xml>", 1)
+	msgSignature := pb.GenSignature(serverToken, "1426139593", "1326298654", msgEncrypt)
+
+	tests := []struct {
+		name         string
+		msgSignature string
+		status       int
+	}{
+		{"valid msg_signature", msgSignature, http.StatusOK},
+		{"invalid msg_signature", strings.Repeat("0", len(msgSignature)), http.StatusBadRequest},
+		{"no msg_signature", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		query := signedQuery("1426139593", "1326298654")
+		query.Set("encrypt_type", "aes")
+		query.Set("msg_signature", tt.msgSignature)
+		resp, err := http.Post(ts.URL+"?"+query.Encode(), "text/xml", strings.NewReader(reqBody))
+		if err != nil {
+			t.Fatal("Post error:", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: want[%d], but actually[%d]", tt.name, tt.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestServerDedupe(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	release := make(chan struct{})
+	handler := pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		return echoHandler(ctx, pkg)
+	})
+
+	s := mp.NewServer(appID, serverToken, serverEncodingAESKey, handler)
+	s.Dedupe = pb.NewMemoryDedupeStore()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	post := func() string {
+		query := signedQuery("1426139593", "1326298654")
+		resp, err := http.Post(ts.URL+"?"+query.Encode(), "text/xml", strings.NewReader(textMsg))
+		if err != nil {
+			t.Error("Post error:", err)
+			return ""
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// The retry arrives while the first delivery is being handled.
+	bodies := make(chan string, 2)
+	go func() { bodies <- post() }()
+	go func() { bodies <- post() }()
+	time.Sleep(300 * time.Millisecond)
+	close(release)
+	for i := 0; i < 2; i++ {
+		if body := <-bodies; body != replyMsg {
+			t.Errorf("Reply: want[%s], but actually[%s]", replyMsg, body)
+		}
+	}
+
+	// The retry arrives after the first delivery is answered.
+	if body := post(); body != replyMsg {
+		t.Errorf("Reply: want[%s], but actually[%s]", replyMsg, body)
+	}
+
+	// Another message.
+	msg := strings.Replace(textMsg, "1234567890123456", "1234567890123457", 1)
+	query := signedQuery("1426139593", "1326298654")
+	resp, err := http.Post(ts.URL+"?"+query.Encode(), "text/xml", strings.NewReader(msg))
+	if err != nil {
+		t.Fatal("Post error:", err)
+	}
+	resp.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Errorf("Handler calls: want[%d], but actually[%d]", 2, calls)
+	}
+}
+
+func TestServerDedupePanic(t *testing.T) {
+	calls := 0
+	handler := pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		return echoHandler(ctx, pkg)
+	})
+
+	s := mp.NewServer(appID, serverToken, serverEncodingAESKey, handler)
+	s.Dedupe = pb.NewMemoryDedupeStore()
+
+	// The retry is handled again after the first delivery panics.
+	query := signedQuery("1426139593", "1326298654")
+	func() {
+		defer func() { recover() }()
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/callback?"+query.Encode(), strings.NewReader(textMsg)))
+	}()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/callback?"+query.Encode(), strings.NewReader(textMsg)))
+	if w.Body.String() != replyMsg {
+		t.Errorf("Reply: want[%s], but actually[%s]", replyMsg, w.Body.String())
+	}
+}
+
+func TestServerAsyncPanic(t *testing.T) {
+	calls := 0
+	handler := pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		return echoHandler(ctx, pkg)
+	})
+
+	s := mp.NewServer(appID, serverToken, serverEncodingAESKey, handler)
+	s.Dedupe = pb.NewMemoryDedupeStore()
+	s.Deliver = func(ctx context.Context, pkg interface{}, reply pb.Reply) error {
+		t.Errorf("Deliver: want no delivery, but actually[%v]", reply)
+		return nil
+	}
+	s.AsyncDeadline = time.Second
+
+	// The panic is answered as busy at once, and the retry is handled
+	// again rather than answered with the empty reply of the first one.
+	query := signedQuery("1426139593", "1326298654")
+	start := time.Now()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/callback?"+query.Encode(), strings.NewReader(textMsg)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status: want[%d], but actually[%d]", http.StatusServiceUnavailable, w.Code)
+	}
+	if d := time.Since(start); d >= s.AsyncDeadline {
+		t.Errorf("Duration: want less than [%s], but actually[%s]", s.AsyncDeadline, d)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/callback?"+query.Encode(), strings.NewReader(textMsg)))
+	if w.Body.String() != replyMsg {
+		t.Errorf("Reply: want[%s], but actually[%s]", replyMsg, w.Body.String())
+	}
+}
+
+func TestServerAsync(t *testing.T) {
+	sent := make(chan string, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cgi-bin/message/custom/send", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		sent <- string(body)
+		w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+	})
+	api, c := newStandInServer(mux)
+	defer api.Close()
+
+	router := mp.NewRouter()
+	router.OnText(func(ctx context.Context, pkg *mp.RecvTextDataPkg) pb.Reply {
+		if pkg.Content == "slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return mp.NewRecvRespTextDataPkg(pkg, "re: "+pkg.Content)
+	})
+	s := mp.NewServer(appID, serverToken, serverEncodingAESKey, router)
+	s.Deliver = c.DeliverReply
+	s.AsyncDeadline = 50 * time.Millisecond
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	post := func(content string) string {
+		msg := strings.Replace(textMsg, "hello body", content, 1)
+		query := signedQuery("1426139593", "1326298654")
+		resp, err := http.Post(ts.URL+"?"+query.Encode(), "text/xml", strings.NewReader(msg))
+		if err != nil {
+			t.Fatal("Post error:", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// Replied within the deadline.
+	if body := post("fast"); !strings.Contains(body, "<Content><![CDATA[re: fast]]></Content>") {
+		t.Errorf("Reply: want[re: fast], but actually[%s]", body)
+	}
+	select {
+	case msg := <-sent:
+		t.Errorf("Sent: want nothing, but actually[%s]", msg)
+	default:
+	}
+
+	// Delivered later.
+	if body := post("slow"); body != "success" {
+		t.Errorf("Reply: want[%s], but actually[%s]", "success", body)
+	}
+	want := `{"touser":"oDF3iY9ffA-hqb2vVvbr7qxf6A0Q","msgtype":"text","text":{"content":"re: slow"}}`
+	select {
+	case msg := <-sent:
+		if msg != want {
+			t.Errorf("Sent: want[%s], but actually[%s]", want, msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("Sent: want a custom message, but actually nothing")
+	}
+}
+
+func TestServerAsyncHandlerDeadline(t *testing.T) {
+	router := mp.NewRouter()
+	router.HandleType((*mp.RecvTextDataPkg)(nil), pb.WithDeadline(pb.MsgHandlerFunc(
+		func(ctx context.Context, pkg interface{}) pb.Reply {
+			time.Sleep(100 * time.Millisecond)
+			return echoHandler(ctx, pkg)
+		}), 20*time.Millisecond))
+
+	delivered := make(chan pb.Reply, 1)
+	s := mp.NewServer(appID, serverToken, serverEncodingAESKey, router)
+	s.Deliver = func(ctx context.Context, pkg interface{}, reply pb.Reply) error {
+		delivered <- reply
+		return nil
+	}
+	s.AsyncWorkers = 1
+	s.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	query := signedQuery("1426139593", "1326298654")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/callback?"+query.Encode(), strings.NewReader(textMsg)))
+	if w.Body.String() != "success" {
+		t.Errorf("Reply: want[%s], but actually[%s]", "success", w.Body.String())
+	}
+
+	// The only worker is busy.
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/callback?"+query.Encode(), strings.NewReader(textMsg)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status: want[%d], but actually[%d]", http.StatusServiceUnavailable, w.Code)
+	}
+
+	select {
+	case reply := <-delivered:
+		if string(reply.([]byte)) != replyMsg {
+			t.Errorf("Delivered: want[%s], but actually[%s]", replyMsg, reply)
+		}
+	case <-time.After(time.Second):
+		t.Error("Delivered: want a reply, but actually nothing")
+	}
+}
+
+func TestServerReplayGuard(t *testing.T) {
+	s := mp.NewServer(appID, serverToken, serverEncodingAESKey, echoHandler,
+		pb.WithReplayGuard(time.Minute, pb.NewMemoryDedupeStore()))
+	s.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	post := func(timestamp string) int {
+		query := signedQuery(timestamp, "1326298654")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("POST", "/callback?"+query.Encode(), strings.NewReader(textMsg)))
+		return w.Code
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if code := post(now); code != http.StatusOK {
+		t.Errorf("Status: want[%d], but actually[%d]", http.StatusOK, code)
+	}
+	// Replayed.
+	if code := post(now); code != http.StatusBadRequest {
+		t.Errorf("Status of replayed: want[%d], but actually[%d]", http.StatusBadRequest, code)
+	}
+	// Stale.
+	if code := post("1426139593"); code != http.StatusBadRequest {
+		t.Errorf("Status of stale: want[%d], but actually[%d]", http.StatusBadRequest, code)
+	}
+
+	verify := func(nonce string) int {
+		query := signedQuery(now, nonce)
+		query.Set("echostr", "4362985891886127916")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/callback?"+query.Encode(), nil))
+		return w.Code
+	}
+	if code := verify("1326298655"); code != http.StatusOK {
+		t.Errorf("Status of verify: want[%d], but actually[%d]", http.StatusOK, code)
+	}
+	if code := verify("1326298655"); code != http.StatusForbidden {
+		t.Errorf("Status of replayed verify: want[%d], but actually[%d]", http.StatusForbidden, code)
+	}
+}
+
+func TestServerReplayGuardDedupe(t *testing.T) {
+	calls := 0
+	handler := pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		calls++
+		return echoHandler(ctx, pkg)
+	})
+	s := mp.NewServer(appID, serverToken, serverEncodingAESKey, handler,
+		pb.WithReplayGuard(time.Minute, pb.NewMemoryDedupeStore()))
+	s.Dedupe = pb.NewMemoryDedupeStore()
+	s.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	post := func(msg string) (int, string) {
+		query := signedQuery(now, "1326298654")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("POST", "/callback?"+query.Encode(), strings.NewReader(msg)))
+		return w.Code, w.Body.String()
+	}
+
+	// The retries of wechat platform with the same nonce are answered by
+	// Dedupe.
+	for i := 0; i < 3; i++ {
+		if code, body := post(textMsg); code != http.StatusOK || body != replyMsg {
+			t.Errorf("Response: want[200 %s], but actually[%d %s]", replyMsg, code, body)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Handler calls: want[%d], but actually[%d]", 1, calls)
+	}
+
+	// Another message with the same nonce is replayed.
+	msg := strings.Replace(textMsg, "1234567890123456", "1234567890123457", 1)
+	if code, _ := post(msg); code != http.StatusBadRequest {
+		t.Errorf("Status of replayed: want[%d], but actually[%d]", http.StatusBadRequest, code)
+	}
+	if calls != 1 {
+		t.Errorf("Handler calls: want[%d], but actually[%d]", 1, calls)
+	}
+}
+
+func TestServerPlainMsgWithoutKey(t *testing.T) {
+	// The EncodingAESKey is not needed in the plain mode.
+	s := mp.NewServer(appID, serverToken, "", echoHandler)
+
+	query := signedQuery("1426139593", "1326298654")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/callback?"+query.Encode(), strings.NewReader(textMsg)))
+	if w.Body.String() != replyMsg {
+		t.Errorf("Reply: want[%s], but actually[%s]", replyMsg, w.Body.String())
+	}
+}
@@ -2,11 +2,9 @@
 package pb
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
+	"context"
+	"errors"
+	"net/url"
 )
 
 // AccessTokenResponse stores the normal result of access token fetching.
@@ -16,38 +14,27 @@ type AccessTokenResponse struct {
 }
 
 // AccessTokenErrorResponse stores the error result of access token fetching.
+//
+// Deprecated: FetchAccessToken returns the error result as an *APIError.
 type AccessTokenErrorResponse struct {
-	Errcode string
+	Errcode ErrorCode
 	Errmsg  string
 }
 
 // FetchAccessToken provides underlying access token fetching implementation.
-func FetchAccessToken(requestLine string) (string, float64, error) {
-	resp, err := http.Get(requestLine)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return "", 0.0, err
-	}
+// It requests path with query through c.
+func FetchAccessToken(c *Client, path string, query url.Values) (string, float64, error) {
+	return FetchAccessTokenContext(context.Background(), c, path, query)
+}
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+// FetchAccessTokenContext is like FetchAccessToken with a context.
+func FetchAccessTokenContext(ctx context.Context, c *Client, path string, query url.Values) (string, float64, error) {
+	atr := AccessTokenResponse{}
+	if err := c.DoJSON(ctx, path, query, nil, &atr); err != nil {
 		return "", 0.0, err
 	}
-
-	//Json Decoding
-	if bytes.Contains(body, []byte("access_token")) {
-		atr := AccessTokenResponse{}
-		err = json.Unmarshal(body, &atr)
-		if err != nil {
-			return "", 0.0, err
-		}
-		return atr.AccessToken, atr.ExpiresIn, nil
-	}
-
-	ater := AccessTokenErrorResponse{}
-	err = json.Unmarshal(body, &ater)
-	if err != nil {
-		return "", 0.0, err
+	if atr.AccessToken == "" {
+		return "", 0.0, errors.New("no access_token in the result")
 	}
-	return "", 0.0, fmt.Errorf("%s", ater.Errmsg)
+	return atr.AccessToken, atr.ExpiresIn, nil
 }
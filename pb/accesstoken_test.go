@@ -1,8 +1,11 @@
 package pb_test
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -10,10 +13,10 @@ import (
 )
 
 const (
-	accessTokenFetchURL = "http://127.0.0.1:9000/cgi-bin/gettoken"
-	corpID              = "wxfd4448417439fd3x"
-	secret              = "p_VQovLdSPNXP0caBalViFvAG_mIpR4bECn-fD1F9JRBut471AcJYXK14SOG1Zld"
-	accessToken         = "accesstoken000001"
+	accessTokenFetchPath = "/cgi-bin/gettoken"
+	corpID               = "wxfd4448417439fd3x"
+	secret               = "p_VQovLdSPNXP0caBalViFvAG_mIpR4bECn-fD1F9JRBut471AcJYXK14SOG1Zld"
+	accessToken          = "accesstoken000001"
 )
 
 func tokenFetchHandler(w http.ResponseWriter, r *http.Request) {
@@ -54,18 +57,28 @@ func tokenFetchHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(respData))
 }
 
-func setupForAccessToken() {
-	// Create a server for access token fetching test.
-	http.HandleFunc("/cgi-bin/gettoken", tokenFetchHandler)
-	http.ListenAndServe(":9000", nil)
+func setupForAccessToken() (*httptest.Server, *pb.Client) {
+	// Create a stand-in server for access token fetching test.
+	mux := http.NewServeMux()
+	mux.HandleFunc(accessTokenFetchPath, tokenFetchHandler)
+	ts := httptest.NewServer(mux)
+	return ts, pb.NewClient(ts.URL)
+}
+
+func fetchAccessToken(c *pb.Client, myCorpID, mySecret string) (string, float64, error) {
+	query := url.Values{}
+	query.Set("corpid", myCorpID)
+	query.Set("corpsecret", mySecret)
+	return pb.FetchAccessToken(c, accessTokenFetchPath, query)
 }
 
 func TestFetchAccessTokenOk(t *testing.T) {
 	myCorpID := "wxfd4448417439fd3x"
 	mySecret := "p_VQovLdSPNXP0caBalViFvAG_mIpR4bECn-fD1F9JRBut471AcJYXK14SOG1Zld"
-	myURL := accessTokenFetchURL + "?corpid=" + myCorpID + "&corpsecret=" + mySecret
+	ts, c := setupForAccessToken()
+	defer ts.Close()
 
-	token, expiresIn, err := pb.FetchAccessToken(myURL)
+	token, expiresIn, err := fetchAccessToken(c, myCorpID, mySecret)
 	if err != nil {
 		t.Fatal("Fetch accesstoken error:", err)
 	}
@@ -82,23 +95,43 @@ func TestFetchAccessTokenOk(t *testing.T) {
 func TestCorpidInvalid(t *testing.T) {
 	myCorpID := "wxfd4448417439fd3y"
 	mySecret := "p_VQovLdSPNXP0caBalViFvAG_mIpR4bECn-fD1F9JRBut471AcJYXK14SOG1Zld"
-	myURL := accessTokenFetchURL + "?corpid=" + myCorpID + "&corpsecret=" + mySecret
+	ts, c := setupForAccessToken()
+	defer ts.Close()
 
-	_, _, err := pb.FetchAccessToken(myURL)
-	errStr := fmt.Sprintf("%s", err)
-	if errStr != "invalid corpid" {
-		t.Errorf("Errmsg: want[%s], but actually[%s]", "invalid corpid", errStr)
+	_, _, err := fetchAccessToken(c, myCorpID, mySecret)
+	var apiErr *pb.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Err: want *pb.APIError, but actually[%v]", err)
+	}
+	if apiErr.Errcode != 40013 {
+		t.Errorf("Errcode: want[%d], but actually[%d]", 40013, apiErr.Errcode)
+	}
+	if apiErr.Errmsg != "invalid corpid" {
+		t.Errorf("Errmsg: want[%s], but actually[%s]", "invalid corpid", apiErr.Errmsg)
+	}
+	if apiErr.Endpoint != accessTokenFetchPath {
+		t.Errorf("Endpoint: want[%s], but actually[%s]", accessTokenFetchPath, apiErr.Endpoint)
 	}
 }
 
 func TestSecretInvalid(t *testing.T) {
 	myCorpID := "wxfd4448417439fd3x"
 	mySecret := "p_VQovLdSPNXP0caBalViFvAG_mIpR4bECn-fD1F9JRBut471AcJYXK14SOG1Zle"
-	myURL := accessTokenFetchURL + "?corpid=" + myCorpID + "&corpsecret=" + mySecret
+	ts, c := setupForAccessToken()
+	defer ts.Close()
 
-	_, _, err := pb.FetchAccessToken(myURL)
-	errStr := fmt.Sprintf("%s", err)
-	if errStr != "invalid corpSecret" {
-		t.Errorf("Errmsg: want[%s], but actually[%s]", "invalid corpSecret", errStr)
+	_, _, err := fetchAccessToken(c, myCorpID, mySecret)
+	var apiErr *pb.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Err: want *pb.APIError, but actually[%v]", err)
+	}
+	if apiErr.Errcode != 40014 {
+		t.Errorf("Errcode: want[%d], but actually[%d]", 40014, apiErr.Errcode)
+	}
+	if apiErr.Errmsg != "invalid corpSecret" {
+		t.Errorf("Errmsg: want[%s], but actually[%s]", "invalid corpSecret", apiErr.Errmsg)
+	}
+	if apiErr.Endpoint != accessTokenFetchPath {
+		t.Errorf("Endpoint: want[%s], but actually[%s]", accessTokenFetchPath, apiErr.Endpoint)
 	}
 }
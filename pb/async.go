@@ -0,0 +1,150 @@
+// Package pb provides the async handling of callback messages.
+package pb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultAsyncDeadline is the default time a handler has to reply in
+	// the async mode, which is within the 5 seconds callback window of
+	// wechat platform.
+	DefaultAsyncDeadline = 4 * time.Second
+
+	// DefaultAsyncWorkers is the default number of the handlers running
+	// at the same time in the async mode.
+	DefaultAsyncWorkers = 64
+)
+
+// ErrWorkersBusy is returned when no worker is available for a message
+// within its deadline in the async mode.
+var ErrWorkersBusy = errors.New("all the async workers are busy")
+
+// DeliverFunc delivers the reply of the received message package pkg
+// which is not ready within the deadline, such as by the custom service
+// message of mp. mp.Client.DeliverReply and qy.Client.DeliverReply
+// implement it.
+type DeliverFunc func(ctx context.Context, pkg interface{}, reply Reply) error
+
+// MsgDeadliner is implemented by the MsgHandler which has its own deadline
+// to reply pkg in the async mode. A zero deadline means the default one.
+type MsgDeadliner interface {
+	MsgDeadline(pkg interface{}) time.Duration
+}
+
+// WithDeadline returns a MsgHandler which handles the messages with h,
+// and has deadline d to reply in the async mode. It could be registered
+// in Router to give a slow handler a shorter deadline.
+func WithDeadline(h MsgHandler, d time.Duration) MsgHandler {
+	return &deadlineHandler{MsgHandler: h, deadline: d}
+}
+
+type deadlineHandler struct {
+	MsgHandler
+	deadline time.Duration
+}
+
+// MsgDeadline implements MsgDeadliner.
+func (h *deadlineHandler) MsgDeadline(pkg interface{}) time.Duration {
+	return h.deadline
+}
+
+// MsgDeadline implements MsgDeadliner with the deadline of the handler
+// for pkg.
+func (r *Router) MsgDeadline(pkg interface{}) time.Duration {
+	if d, ok := r.handler(pkg).(MsgDeadliner); ok {
+		return d.MsgDeadline(pkg)
+	}
+	return 0
+}
+
+// dispatch dispatches pkg to Handler. In the async mode, the handler runs
+// in a worker; if it does not reply within the deadline, nil is returned
+// and the reply is delivered by Deliver when it is ready. If the handler
+// panics within the deadline, an error is returned.
+func (s *Server) dispatch(ctx context.Context, pkg interface{}) (Reply, error) {
+	if s.Deliver == nil {
+		return s.Handler.ServeMsg(ctx, pkg), nil
+	}
+
+	timer := time.NewTimer(s.deadline(pkg))
+	defer timer.Stop()
+
+	workers := s.workers()
+	select {
+	case workers <- struct{}{}:
+	case <-timer.C:
+		return nil, ErrWorkersBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// The handler outlives the request if it is late.
+	hctx := context.WithoutCancel(ctx)
+	done := make(chan Reply)
+	errc := make(chan error)
+	late := make(chan struct{})
+	go func() {
+		defer func() { <-workers }()
+		defer func() {
+			if v := recover(); v != nil {
+				s.logf("handle callback message panic: %v", v)
+				select {
+				case errc <- fmt.Errorf("handler panic: %v", v):
+				case <-late:
+				}
+			}
+		}()
+
+		reply := s.Handler.ServeMsg(hctx, pkg)
+		select {
+		case done <- reply:
+		case <-late:
+			if reply == nil {
+				return
+			}
+			if err := s.Deliver(hctx, pkg, reply); err != nil {
+				s.logf("deliver reply error: %v", err)
+			}
+		}
+	}()
+
+	select {
+	case reply := <-done:
+		return reply, nil
+	case err := <-errc:
+		return nil, err
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	close(late)
+	return nil, nil
+}
+
+// deadline returns the deadline of the handler for pkg.
+func (s *Server) deadline(pkg interface{}) time.Duration {
+	if h, ok := s.Handler.(MsgDeadliner); ok {
+		if d := h.MsgDeadline(pkg); d > 0 {
+			return d
+		}
+	}
+	if s.AsyncDeadline > 0 {
+		return s.AsyncDeadline
+	}
+	return DefaultAsyncDeadline
+}
+
+// workers returns the semaphore bounding the running handlers.
+func (s *Server) workers() chan struct{} {
+	s.workersOnce.Do(func() {
+		n := s.AsyncWorkers
+		if n <= 0 {
+			n = DefaultAsyncWorkers
+		}
+		s.sem = make(chan struct{}, n)
+	})
+	return s.sem
+}
@@ -0,0 +1,303 @@
+// Package pb provides the underlying api client for qy and mp.
+package pb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client holds the settings shared by all the outbound calls to wechat
+// platform. qy.Client and mp.Client are built on it.
+type Client struct {
+	// BaseURL is the scheme and host of wechat api, such as
+	// "https://api.weixin.qq.com". It could be pointed to a local
+	// stand-in server in tests or to an egress proxy in production.
+	BaseURL string
+
+	// HTTPClient is used to send the requests. http.DefaultClient is
+	// used if it is nil.
+	HTTPClient *http.Client
+
+	// Timeout limits the time of one request. Zero means no timeout
+	// besides the one of HTTPClient.
+	Timeout time.Duration
+
+	// TokenSource, if not nil, provides the access_token of the api calls
+	// such as SendMsg and CreateMenu. A call failed for an invalid or
+	// expired token is retried once with a refreshed token.
+	TokenSource TokenSource
+
+	// Limiter, if not nil, limits the rate of the calls per endpoint.
+	Limiter *Limiter
+
+	// Quota, if not nil, counts the calls made per endpoint per day.
+	Quota *QuotaCounter
+
+	// OnRateLimited, if not nil, is called when a call fails with a
+	// rate limit errcode, such as 45009 or 45047.
+	OnRateLimited func(ctx context.Context, err *APIError)
+
+	// RateLimitRetries is how many times a call failed with one of
+	// RetryErrcodes is retried. The first retry is made after
+	// RateLimitBackoff, and the backoff is doubled for every retry. Zero
+	// means no retry.
+	RateLimitRetries int
+	RateLimitBackoff time.Duration
+
+	// RetryErrcodes are the rate limit errcodes of the calls which would
+	// succeed soon, so they are retried. DefaultRetryErrcodes is used if
+	// it is nil. The calls exceeding the daily quota or the other limits
+	// are never retried.
+	RetryErrcodes []int
+
+	// Middlewares wrap the transport of HTTPClient for every request,
+	// the first one is the outermost. The chain is built once at the first
+	// request, so Middlewares and HTTPClient should not be changed after it.
+	Middlewares []Middleware
+
+	chainOnce sync.Once
+	chained   *http.Client
+}
+
+// DefaultRetryErrcodes are the errcodes retried by a Client without
+// RetryErrcodes: the per minute limit of mp, errcode 45011. qy.NewClient
+// sets the frequency and concurrency limits of qy instead.
+var DefaultRetryErrcodes = []int{ErrcodeMinuteQuotaExceeded}
+
+// TokenSource provides the access token. TokenManager implements it.
+type TokenSource interface {
+	// Token returns a valid access token.
+	Token(ctx context.Context) (string, error)
+	// Refresh discards the access token stale, which is rejected by
+	// wechat platform, and returns a new one. If stale has been refreshed
+	// already, the new one is returned without refreshing again.
+	Refresh(ctx context.Context, stale string) (string, error)
+}
+
+// NewClient creates a Client for the api located in baseURL.
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: baseURL}
+}
+
+// URL returns the full request line for path and query.
+func (c *Client) URL(path string, query url.Values) string {
+	u := strings.TrimRight(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (c *Client) httpClient() *http.Client {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	if len(c.Middlewares) == 0 {
+		return hc
+	}
+
+	c.chainOnce.Do(func() {
+		transport := hc.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		chained := *hc
+		chained.Transport = chain(transport, c.Middlewares)
+		c.chained = &chained
+	})
+	return c.chained
+}
+
+// Do sends a request to path and returns the response body
+// and the http status code.
+func (c *Client) Do(method, path string, query url.Values, body io.Reader, contentType string) ([]byte, int, error) {
+	return c.DoContext(context.Background(), method, path, query, body, contentType)
+}
+
+// DoContext is like Do, but the request is canceled when ctx is done.
+func (c *Client) DoContext(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) ([]byte, int, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest(method, c.URL(path, query), body)
+	if err != nil {
+		return nil, 0, err
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		// The error carries the url, keep the credentials in it out of logs.
+		return nil, 0, redactError(err, req.URL)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return respBody, resp.StatusCode, nil
+}
+
+// DoJSON performs a GET request if reqBody is nil, otherwise a POST request
+// with reqBody as its json body. reqBody in []byte is seemed as encoded json
+// and is sent as is. A non-200 http status or a non-zero errcode in the
+// result is returned as an *APIError, otherwise the result is decoded into
+// result unless it is nil.
+//
+// DoJSON waits for c.Limiter and is counted in c.Quota. If the call is
+// rate limited, c.OnRateLimited is called, and the call failed with one of
+// c.RetryErrcodes is retried as c.RateLimitRetries says.
+func (c *Client) DoJSON(ctx context.Context, path string, query url.Values, reqBody, result interface{}) error {
+	method := "GET"
+	var data []byte
+	var contentType string
+	if reqBody != nil {
+		var ok bool
+		if data, ok = reqBody.([]byte); !ok {
+			var err error
+			if data, err = json.Marshal(reqBody); err != nil {
+				return err
+			}
+		}
+		method = "POST"
+		contentType = "application/json; encoding=utf-8"
+	}
+
+	backoff := c.RateLimitBackoff
+	for retries := 0; ; retries++ {
+		err := c.doJSONOnce(ctx, method, path, query, data, contentType, result)
+		var apiErr *APIError
+		if !IsRateLimited(err) || !errors.As(err, &apiErr) {
+			return err
+		}
+
+		if c.OnRateLimited != nil {
+			c.OnRateLimited(ctx, apiErr)
+		}
+		if !c.retryable(apiErr.Errcode) || retries >= c.RateLimitRetries {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether the call failed with errcode is retried.
+func (c *Client) retryable(errcode int) bool {
+	errcodes := c.RetryErrcodes
+	if errcodes == nil {
+		errcodes = DefaultRetryErrcodes
+	}
+	for _, e := range errcodes {
+		if e == errcode {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) doJSONOnce(ctx context.Context, method, path string, query url.Values, data []byte, contentType string, result interface{}) error {
+	if c.Limiter != nil {
+		if err := c.Limiter.Wait(ctx, path); err != nil {
+			return err
+		}
+	}
+	if c.Quota != nil {
+		c.Quota.Add(path)
+	}
+
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	respBody, statusCode, err := c.DoContext(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+
+	errResp := &struct {
+		Errcode ErrorCode `json:"errcode"`
+		Errmsg  string    `json:"errmsg"`
+	}{}
+	jsonErr := json.Unmarshal(respBody, errResp)
+	if statusCode != http.StatusOK || (jsonErr == nil && errResp.Errcode != ErrcodeOK) {
+		return &APIError{
+			Errcode:    int(errResp.Errcode),
+			Errmsg:     errResp.Errmsg,
+			Endpoint:   path,
+			StatusCode: statusCode,
+		}
+	}
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+// CallJSON is like DoJSON, but the request carries the access token of
+// c.TokenSource. If the call fails for the token is invalid, the token is
+// refreshed and the call is retried once.
+func (c *Client) CallJSON(ctx context.Context, path string, query url.Values, reqBody, result interface{}) error {
+	return c.withToken(ctx, query, func(query url.Values) error {
+		return c.DoJSON(ctx, path, query, reqBody, result)
+	})
+}
+
+// withToken invokes call with query carrying the access token of
+// c.TokenSource. If call fails for the token is invalid, the token is
+// refreshed and call is retried once. If c.TokenSource is nil, query is
+// used as is.
+func (c *Client) withToken(ctx context.Context, query url.Values, call func(url.Values) error) error {
+	if c.TokenSource == nil {
+		return call(query)
+	}
+
+	token, err := c.TokenSource.Token(ctx)
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("access_token", token)
+
+	err = call(q)
+	if !IsTokenExpired(err) {
+		return err
+	}
+
+	if token, err = c.TokenSource.Refresh(ctx, token); err != nil {
+		return err
+	}
+	q.Set("access_token", token)
+	return call(q)
+}
@@ -0,0 +1,103 @@
+package pb_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+func TestClientURL(t *testing.T) {
+	c := pb.NewClient("https://api.weixin.qq.com/")
+	query := url.Values{}
+	query.Set("access_token", "accesstoken000001")
+
+	want := "https://api.weixin.qq.com/cgi-bin/menu/create?access_token=accesstoken000001"
+	if got := c.URL("/cgi-bin/menu/create", query); got != want {
+		t.Errorf("URL: want[%s], but actually[%s]", want, got)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	c := pb.NewClient(ts.URL)
+	c.HTTPClient = ts.Client()
+	c.Timeout = 50 * time.Millisecond
+
+	if _, _, err := c.Do("GET", "/slow", nil, nil, ""); err == nil {
+		t.Error("want timeout error, but actually it returns nil")
+	}
+}
+
+func TestDoJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/get":
+			w.Write([]byte(`{"access_token": "accesstoken000001", "expires_in": 7200}`))
+		case "/post":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Method != "POST" || string(body) != `{"touser":"tonybai","msgtype":"text","text":{"content":"hello body"}}` {
+				w.Write([]byte(`{"errcode": 40008, "errmsg": "invalid message type"}`))
+				return
+			}
+			w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+		case "/busy":
+			w.Write([]byte(`{"errcode": -1, "errmsg": "system busy"}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+	c := pb.NewClient(ts.URL)
+
+	atr := &pb.AccessTokenResponse{}
+	if err := c.DoJSON(context.Background(), "/get", nil, nil, atr); err != nil {
+		t.Fatal("DoJSON error:", err)
+	}
+	if atr.AccessToken != accessToken || atr.ExpiresIn != 7200 {
+		t.Errorf("Result: want[%s %d], but actually[%s %v]", accessToken, 7200, atr.AccessToken, atr.ExpiresIn)
+	}
+
+	pkg := &pb.SendMsgTextPkg{ToUser: "tonybai", MsgType: "text", Text: pb.TextContent{Content: "hello body"}}
+	if err := c.DoJSON(context.Background(), "/post", nil, pkg, nil); err != nil {
+		t.Error("DoJSON error:", err)
+	}
+
+	err := c.DoJSON(context.Background(), "/busy", nil, nil, nil)
+	if !pb.IsSystemBusy(err) {
+		t.Errorf("Err: want a system busy error, but actually[%v]", err)
+	}
+
+	err = c.DoJSON(context.Background(), "/bad", nil, nil, nil)
+	var apiErr *pb.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Err: want an APIError with status 502, but actually[%v]", err)
+	}
+}
+
+func TestFetchAccessTokenBadStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	_, _, err := pb.FetchAccessToken(pb.NewClient(ts.URL), accessTokenFetchPath, nil)
+	if err == nil {
+		t.Error("want an error for http status 503, but actually it returns nil")
+	}
+}
@@ -4,46 +4,121 @@ package pb
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
-	"io"
+	"fmt"
 )
 
-// DecryptMsg is used to descrpyt the encrypted msg from wechat.
-// it returns the origData of the cipherText. cipherText is msg_encrypt.
-// origData = AES_Decrypt(Base64_Decode[cipherText])
-func DecryptMsg(cipherText, encodingAESKey string) ([]byte, error) {
-	var cipherData []byte
-	var err error
-	if cipherData, err = base64.StdEncoding.DecodeString(cipherText); err != nil {
+// EncodingAESKeyLen is the length of EncodingAESKey.
+const EncodingAESKeyLen = 43
+
+var (
+	// ErrInvalidPadding is returned when the PKCS #7 padding of the
+	// decrypted data is invalid.
+	ErrInvalidPadding = errors.New("invalid padding")
+
+	// ErrBadLength is returned when the length of the cipher data, or the
+	// msg_len in the decrypted data, is invalid.
+	ErrBadLength = errors.New("bad length")
+
+	// ErrAppIDMismatch is returned when the appID or corpID in the
+	// decrypted data is not the expected one.
+	ErrAppIDMismatch = errors.New("appid mismatch")
+)
+
+// randomLen is the length of the random bytes ahead of msg_len.
+const randomLen = 16
+
+// Crypter encrypts and decrypts the messages of wechat platform with the
+// AESKey of an EncodingAESKey, following the spec of wechat: AES-256-CBC
+// with the first 16 bytes of AESKey as IV, and PKCS #7 padding to 32 bytes.
+// It is safe for concurrent use.
+type Crypter struct {
+	key   []byte
+	block cipher.Block
+}
+
+// NewCrypter creates a Crypter with encodingAESKey, which is 43 characters
+// set in the admin console of wechat platform.
+func NewCrypter(encodingAESKey string) (*Crypter, error) {
+	if len(encodingAESKey) != EncodingAESKeyLen {
+		return nil, fmt.Errorf("invalid EncodingAESKey length %d, want %d", len(encodingAESKey), EncodingAESKeyLen)
+	}
+
+	key, err := encodingAESKey2AESKey(encodingAESKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EncodingAESKey: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
 		return nil, err
 	}
+	return &Crypter{key: key, block: block}, nil
+}
 
-	var AESKey []byte
-	if AESKey, err = encodingAESKey2AESKey(encodingAESKey); err != nil {
+// Decrypt returns the origData of cipherText, which is msg_encrypt.
+// origData = AES_Decrypt(Base64_Decode[cipherText])
+func (c *Crypter) Decrypt(cipherText string) ([]byte, error) {
+	cipherData, err := base64.StdEncoding.DecodeString(cipherText)
+	if err != nil {
 		return nil, err
 	}
+	return c.aesDecrypt(cipherData)
+}
 
-	return aesDecrypt(cipherData, AESKey)
+// Encrypt returns the cipherText of origData.
+// cipherText = Base64_Encode(AES_Encrypt [origData])
+func (c *Crypter) Encrypt(origData []byte) (string, error) {
+	return base64.StdEncoding.EncodeToString(c.aesEncrypt(origData)), nil
+}
+
+// DecryptMsg is used to descrpyt the encrypted msg from wechat.
+// it returns the origData of the cipherText. cipherText is msg_encrypt.
+// origData = AES_Decrypt(Base64_Decode[cipherText])
+func DecryptMsg(cipherText, encodingAESKey string) ([]byte, error) {
+	c, err := NewCrypter(encodingAESKey)
+	if err != nil {
+		return nil, err
+	}
+	return c.Decrypt(cipherText)
 }
 
 // EncryptMsg is used to encrpyt the msg being sent to wechat.
 // it returns the cipherText of the origData.
 // cipherText = Base64_Encode(AES_Encrypt [origData])
 func EncryptMsg(origData []byte, encodingAESKey string) (string, error) {
-	var AESKey []byte
-	var err error
-	if AESKey, err = encodingAESKey2AESKey(encodingAESKey); err != nil {
+	c, err := NewCrypter(encodingAESKey)
+	if err != nil {
 		return "", err
 	}
+	return c.Encrypt(origData)
+}
 
-	var cipherData []byte
-	if cipherData, err = aesEncrypt(origData, AESKey); err != nil {
-		return "", err
+// DecodeMsg splits the decrypted origData into msg and appID, which is the
+// corpID for qy. It returns ErrBadLength if msg_len is out of origData.
+// origData = random(16B) + msg_len(4B) + msg + $appID
+func DecodeMsg(origData []byte) ([]byte, string, error) {
+	if len(origData) < randomLen+4 {
+		return nil, "", fmt.Errorf("%w: decrypted data of %d bytes", ErrBadLength, len(origData))
 	}
 
-	return base64.StdEncoding.EncodeToString(cipherData), nil
+	msgLen := uint64(binary.BigEndian.Uint32(origData[randomLen : randomLen+4]))
+	rest := origData[randomLen+4:]
+	if msgLen > uint64(len(rest)) {
+		return nil, "", fmt.Errorf("%w: msg_len %d of %d bytes", ErrBadLength, msgLen, len(rest))
+	}
+	return rest[:msgLen], string(rest[msgLen:]), nil
+}
+
+// CheckAppID returns an error wrapping ErrAppIDMismatch if appID, which is
+// decoded by DecodeMsg, is not want.
+func CheckAppID(appID, want string) error {
+	if appID != want {
+		return fmt.Errorf("%w: the message is from [%s], not from [%s]", ErrAppIDMismatch, appID, want)
+	}
+	return nil
 }
 
 // AESKey = Base64_Decode(EncodingAESKey + "=").
@@ -51,15 +126,15 @@ func encodingAESKey2AESKey(encodingAESKey string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(encodingAESKey + "=")
 }
 
-// unpad strips the PKCS #7 padding on a buffer. If the padding is
-// invalid, nil is returned.
-func unpad(in []byte) []byte {
+// unpad strips the PKCS #7 padding of blockSize on a buffer. If the
+// padding is invalid, nil is returned.
+func unpad(in []byte, blockSize int) []byte {
 	if len(in) == 0 {
 		return nil
 	}
 
 	padding := in[len(in)-1]
-	if int(padding) > len(in) {
+	if int(padding) > len(in) || int(padding) > blockSize {
 		return nil
 	} else if padding == 0 {
 		return nil
@@ -73,40 +148,27 @@ func unpad(in []byte) []byte {
 	return in[:len(in)-int(padding)]
 }
 
-func aesDecrypt(in, k []byte) ([]byte, error) {
-	l := len(k) //PKCS#7
-	if len(in) == 0 || len(in)%l != 0 {
-		return nil, errors.New("cipher data size is zero or not multiple of AESKey length")
-	}
-
-	c, err := aes.NewCipher(k)
-	if err != nil {
-		return nil, err
-	}
+// iv returns the IV of wechat, which is the first 16 bytes of AESKey.
+func (c *Crypter) iv() []byte {
+	return c.key[:aes.BlockSize]
+}
 
-	iv := generateIV()
-	if iv == nil {
-		return nil, errors.New("generateIV error")
+func (c *Crypter) aesDecrypt(in []byte) ([]byte, error) {
+	l := len(c.key) //PKCS#7
+	if len(in) == 0 || len(in)%l != 0 {
+		return nil, fmt.Errorf("%w: cipher data size %d is zero or not multiple of AESKey length", ErrBadLength, len(in))
 	}
 
-	cbc := cipher.NewCBCDecrypter(c, iv)
+	cbc := cipher.NewCBCDecrypter(c.block, c.iv())
 	cbc.CryptBlocks(in, in)
 
-	out := unpad(in)
+	out := unpad(in, l)
 	if out == nil {
-		return nil, errors.New("unpad error")
+		return nil, ErrInvalidPadding
 	}
 	return out, nil
 }
 
-func generateIV() []byte {
-	iv := make([]byte, aes.BlockSize)
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return nil
-	}
-	return iv
-}
-
 // pad applies the PKCS #7 padding scheme on the buffer.
 func pad(in []byte, length int) []byte {
 	padding := length - (len(in) % length)
@@ -121,20 +183,11 @@ func pad(in []byte, length int) []byte {
 
 // aesEncrypt applies the necessary padding to the message and encrypts it
 // with AES-CBC.
-func aesEncrypt(in, k []byte) ([]byte, error) {
-	in = pad(in, len(k))
+func (c *Crypter) aesEncrypt(in []byte) []byte {
+	// Copy in, so that the padding does not overwrite the caller's data.
+	out := pad(append([]byte(nil), in...), len(c.key))
 
-	iv := generateIV()
-	if iv == nil {
-		return nil, errors.New("generateIV error")
-	}
-
-	c, err := aes.NewCipher(k)
-	if err != nil {
-		return nil, err
-	}
-
-	cbc := cipher.NewCBCEncrypter(c, iv)
-	cbc.CryptBlocks(in, in)
-	return in, nil //do not return iv ahead of in
+	cbc := cipher.NewCBCEncrypter(c.block, c.iv())
+	cbc.CryptBlocks(out, out)
+	return out //do not return iv ahead of in
 }
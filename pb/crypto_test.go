@@ -1,6 +1,8 @@
 package pb_test
 
 import (
+	"encoding/base64"
+	"errors"
 	"testing"
 
 	"github.com/bigwhite/gowechat/pb"
@@ -41,6 +43,148 @@ func TestDecryptMsg(t *testing.T) {
 	}
 }
 
+// cryptoVectors are the msg_encrypt samples of wechat platform.
+var cryptoVectors = []struct {
+	msgEncrypt     string
+	encodingAESKey string
+}{
+	{"8xkfcZ4H50bmdMXxUh1fi9sPYboKROAgPN9Obyvjxs/q9CGjRoVJJpGUz3A4XzXSI/faqOZClv0Y+aHlYMqhBpyzO6wd9iIPNcShnPTet/lUisLiz4moEeqEnLrJo25slK5j7zuI0lrLu9EnMArdYFNHd4J/rr+SK3hNh3zyXin+wEC+RuLJG+TG32AizGCcuPfe0db9/jvID8pqWjE/+Q08aaecMWhSDFk2VbWT8I5TKdo/MUsj+NQMg3c5Z4WB0fkSF8JWGz8VDnIofo9FvsFUCc3BvjLqcTldYTHE/65Qn9COdsd9qwAsPZoPdjpFRB5pl3lPjeoSW/WzT+lL5V+Y/5VfcvniZAzVKDoCdtV8Ufzs+H7JRDa/yGGMYT48AY1skYdFA00aUAOJkeTPDEtz8CtZcREYsiSnGMpgFTY=",
+		"jRwY6v82amVaTB4eXdjG775NH8ubF6AwauNed88UfGK"},
+	{"RypEvHKD8QQKFhvQ6QleEB4J58tiPdvo+rtK1I9qca6aM/wvqnLSV5zEPeusUiX5L5X/0lWfrf0QADHHhGd3QczcdCUpj911L3vg3W/sYYvuJTs3TUUkSUXxaccAS0qhxchrRYt66wiSpGLYL42aM6A8dTT+6k4aSknmPj48kzJs8qLjvd4Xgpue06DOdnLxAUHzM6+kDZ+HMZfJYuR+LtwGc2hgf5gsijff0ekUNXZiqATP7PF5mZxZ3Izoun1s4zG4LUMnvw2r+KqCKIw+3IQH03v+BCA9nMELNqbSf6tiWSrXJB3LAVGUcallcrw8V2t9EL4EhzJWrQUax5wLVMNS0+rUPA3k22Ncx4XXZS9o0MBH27Bo6BpNelZpS+/uh9KsNlY6bHCmJU9p8g7m3fVKn28H3KDYA5Pl/T8Z1ptDAVe0lXdQ2YoyyH2uyPIGHBZZIs2pDBS8R07+qN+E7Q==",
+		"jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2C"},
+}
+
 func TestEncryptMsg(t *testing.T) {
+	// With the IV of the spec, encrypting the decrypted samples gives the
+	// samples back.
+	for _, v := range cryptoVectors {
+		c, err := pb.NewCrypter(v.encodingAESKey)
+		if err != nil {
+			t.Fatal("NewCrypter error:", err)
+		}
+		origData, err := c.Decrypt(v.msgEncrypt)
+		if err != nil {
+			t.Fatal("Decrypt error:", err)
+		}
+		msgEncrypt, err := c.Encrypt(origData)
+		if err != nil {
+			t.Fatal("Encrypt error:", err)
+		}
+		if msgEncrypt != v.msgEncrypt {
+			t.Errorf("Encrypt: want[%s], but actually[%s]", v.msgEncrypt, msgEncrypt)
+		}
+
+		msgEncrypt, err = pb.EncryptMsg(origData, v.encodingAESKey)
+		if err != nil || msgEncrypt != v.msgEncrypt {
+			t.Errorf("EncryptMsg: want[%s], but actually[%s %v]", v.msgEncrypt, msgEncrypt, err)
+		}
+	}
+}
+
+func TestCrypterRoundTrip(t *testing.T) {
+	c, err := pb.NewCrypter("jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2C")
+	if err != nil {
+		t.Fatal("NewCrypter error:", err)
+	}
+	// Every length of padding, including a full block.
+	for n := 0; n <= 64; n++ {
+		origData := make([]byte, n)
+		for i := range origData {
+			origData[i] = byte(i)
+		}
+		msgEncrypt, err := c.Encrypt(origData)
+		if err != nil {
+			t.Fatal("Encrypt error:", err)
+		}
+		data, err := c.Decrypt(msgEncrypt)
+		if err != nil {
+			t.Fatalf("Decrypt of %d bytes error: %s", n, err)
+		}
+		if string(data) != string(origData) {
+			t.Errorf("Decrypt of %d bytes: want[%x], but actually[%x]", n, origData, data)
+		}
+	}
+}
+
+func TestNewCrypterInvalidKey(t *testing.T) {
+	keys := []string{
+		"",
+		"jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2",
+		"jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2Cx",
+		"jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2!",
+	}
+	for _, key := range keys {
+		if _, err := pb.NewCrypter(key); err == nil {
+			t.Errorf("NewCrypter(%q): want an error, but actually nil", key)
+		}
+	}
+}
+
+func TestDecodeMsg(t *testing.T) {
+	origData := append([]byte("0123456789abcdef\x00\x00\x00\x05hello"), "wx5823bf96d3bd56c7"...)
+	msg, appID, err := pb.DecodeMsg(origData)
+	if err != nil {
+		t.Fatal("DecodeMsg error:", err)
+	}
+	if string(msg) != "hello" || appID != "wx5823bf96d3bd56c7" {
+		t.Errorf("DecodeMsg: want[hello wx5823bf96d3bd56c7], but actually[%s %s]", msg, appID)
+	}
+
+	bad := [][]byte{
+		nil,
+		[]byte("0123456789abcdef\x00\x00"),
+		[]byte("0123456789abcdef\x00\x00\x00\x06hello"),
+		[]byte("0123456789abcdef\xff\xff\xff\xffhello"),
+	}
+	for _, origData := range bad {
+		if _, _, err := pb.DecodeMsg(origData); !errors.Is(err, pb.ErrBadLength) {
+			t.Errorf("DecodeMsg(%q): want[%v], but actually[%v]", origData, pb.ErrBadLength, err)
+		}
+	}
+}
+
+func TestCheckAppID(t *testing.T) {
+	if err := pb.CheckAppID("wx5823bf96d3bd56c7", "wx5823bf96d3bd56c7"); err != nil {
+		t.Errorf("CheckAppID: want[<nil>], but actually[%v]", err)
+	}
+	if err := pb.CheckAppID("wx2f6d0a549c129f06", "wx5823bf96d3bd56c7"); !errors.Is(err, pb.ErrAppIDMismatch) {
+		t.Errorf("CheckAppID: want[%v], but actually[%v]", pb.ErrAppIDMismatch, err)
+	}
+}
+
+func TestDecryptMalformed(t *testing.T) {
+	v := cryptoVectors[1]
+	c, err := pb.NewCrypter(v.encodingAESKey)
+	if err != nil {
+		t.Fatal("NewCrypter error:", err)
+	}
+	cipherData, _ := base64.StdEncoding.DecodeString(v.msgEncrypt)
+
+	// The last block is gone, so the padding is the message text.
+	truncated := base64.StdEncoding.EncodeToString(cipherData[:len(cipherData)-32])
+	if _, err := c.Decrypt(truncated); !errors.Is(err, pb.ErrInvalidPadding) {
+		t.Errorf("Decrypt truncated: want[%v], but actually[%v]", pb.ErrInvalidPadding, err)
+	}
+
+	odd := base64.StdEncoding.EncodeToString(cipherData[:len(cipherData)-1])
+	if _, err := c.Decrypt(odd); !errors.Is(err, pb.ErrBadLength) {
+		t.Errorf("Decrypt odd size: want[%v], but actually[%v]", pb.ErrBadLength, err)
+	}
+	if _, err := c.Decrypt(""); !errors.Is(err, pb.ErrBadLength) {
+		t.Errorf("Decrypt empty: want[%v], but actually[%v]", pb.ErrBadLength, err)
+	}
+}
 
+func FuzzDecodeMsg(f *testing.F) {
+	f.Add([]byte("0123456789abcdef\x00\x00\x00\x05hellowx5823bf96d3bd56c7"))
+	f.Add([]byte("0123456789abcdef\xff\xff\xff\xff"))
+	f.Fuzz(func(t *testing.T, origData []byte) {
+		msg, appID, err := pb.DecodeMsg(origData)
+		if err != nil {
+			return
+		}
+		if len(msg)+len(appID)+20 != len(origData) {
+			t.Errorf("DecodeMsg(%q): got[%q %q]", origData, msg, appID)
+		}
+	})
 }
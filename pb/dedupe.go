@@ -0,0 +1,186 @@
+// Package pb provides the duplicate-delivery suppression of callback messages.
+package pb
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultDedupeTTL is the default time a received message is remembered,
+// which covers the retries of wechat platform.
+const DefaultDedupeTTL = 5 * time.Minute
+
+// dedupePollInterval is the interval a retry polls DedupeStore for the
+// reply of the message being handled.
+const dedupePollInterval = 100 * time.Millisecond
+
+// DedupeStore remembers the received messages and their replies, so that
+// Server could recognise the retries of wechat platform. It could be shared
+// by the instances of a cluster, such as one based on redis.
+type DedupeStore interface {
+	// Add marks key as being handled for ttl if it is absent, and reports
+	// whether it is added.
+	Add(ctx context.Context, key string, ttl time.Duration) (bool, error)
+
+	// Get returns the reply of key stored by Set. ok is false if key is
+	// absent or is still being handled.
+	Get(ctx context.Context, key string) (reply []byte, ok bool, err error)
+
+	// Set stores the reply of key for ttl. An empty reply means no reply.
+	Set(ctx context.Context, key string, reply []byte, ttl time.Duration) error
+
+	// Delete removes key, so that it could be handled again.
+	Delete(ctx context.Context, key string) error
+}
+
+// DedupeKeyer is implemented by the received message packages whose key
+// for dedupe is not the one derived by DedupeKey. The events sent by the
+// system, such as the contact changes of qy, share FromUserName, CreateTime
+// and Event in a batch, so their key is derived from the other fields, or
+// from all of them by PayloadKey.
+type DedupeKeyer interface {
+	DedupeKey() string
+}
+
+// DedupeKey returns the key of the received message package pkg for
+// dedupe, prefixed with ToUserName. It is the one of pkg if pkg implements
+// DedupeKeyer. Otherwise it is the MsgID of a message, along with Event if
+// it is an event, or FromUserName, CreateTime, Event and EventKey of an
+// event without MsgID. It returns "" if pkg has none of them.
+func DedupeKey(pkg interface{}) string {
+	if k, ok := pkg.(DedupeKeyer); ok {
+		return k.DedupeKey()
+	}
+	recv, ok := pkg.(RecvPkg)
+	if !ok {
+		return ""
+	}
+	base := recv.RecvBase()
+	event, _ := stringField(pkg, "Event")
+
+	if msgID, ok := uintField(pkg, "MsgID"); ok && msgID != 0 {
+		if event != "" {
+			// The MsgID of an event, such as the one of a template
+			// message, is not unique among the messages.
+			return fmt.Sprintf("%s:%s:%d", base.ToUserName, event, msgID)
+		}
+		return fmt.Sprintf("%s:%d", base.ToUserName, msgID)
+	}
+	if base.FromUserName == "" || base.CreateTime == 0 {
+		return ""
+	}
+	key := fmt.Sprintf("%s:%s:%d", base.ToUserName, base.FromUserName, base.CreateTime)
+	if event != "" {
+		// A user may trigger many events in a second, such as subscribe
+		// and LOCATION.
+		eventKey, _ := stringField(pkg, "EventKey")
+		key += ":" + event + ":" + eventKey
+	}
+	return key
+}
+
+// PayloadKey returns a key of pkg for dedupe derived from all its fields,
+// prefixed with ToUserName. It could be used by the DedupeKeyer without
+// any field identifying it.
+func PayloadKey(pkg RecvPkg) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%#v", pkg)))
+	return fmt.Sprintf("%s:%x", pkg.RecvBase().ToUserName, sum)
+}
+
+// uintField returns the unsigned integer field name of the struct pointed by pkg.
+func uintField(pkg interface{}, name string) (uint64, bool) {
+	v := reflect.ValueOf(pkg)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return 0, false
+	}
+	f := v.Elem().FieldByName(name)
+	if !f.IsValid() {
+		return 0, false
+	}
+	switch f.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return f.Uint(), true
+	}
+	return 0, false
+}
+
+// MemoryDedupeStore is a DedupeStore in memory, for the services running
+// in one process. It is safe for concurrent use.
+type MemoryDedupeStore struct {
+	mu        sync.Mutex
+	entries   map[string]dedupeEntry
+	lastSweep time.Time
+}
+
+type dedupeEntry struct {
+	reply     []byte
+	done      bool
+	expiresAt time.Time
+}
+
+// NewMemoryDedupeStore creates an empty MemoryDedupeStore.
+func NewMemoryDedupeStore() *MemoryDedupeStore {
+	return &MemoryDedupeStore{entries: make(map[string]dedupeEntry)}
+}
+
+// Add implements DedupeStore.
+func (s *MemoryDedupeStore) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweepLocked(now, ttl)
+	if e, ok := s.entries[key]; ok && now.Before(e.expiresAt) {
+		return false, nil
+	}
+	s.entries[key] = dedupeEntry{expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+// Get implements DedupeStore.
+func (s *MemoryDedupeStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || !e.done || !time.Now().Before(e.expiresAt) {
+		return nil, false, nil
+	}
+	return e.reply, true, nil
+}
+
+// Set implements DedupeStore.
+func (s *MemoryDedupeStore) Set(ctx context.Context, key string, reply []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = dedupeEntry{reply: reply, done: true, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// Delete implements DedupeStore.
+func (s *MemoryDedupeStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweepLocked removes the expired entries at most once per interval.
+// s.mu must be held.
+func (s *MemoryDedupeStore) sweepLocked(now time.Time, interval time.Duration) {
+	if now.Sub(s.lastSweep) < interval {
+		return
+	}
+	s.lastSweep = now
+	for k, e := range s.entries {
+		if !now.Before(e.expiresAt) {
+			delete(s.entries, k)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package pb_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+type recvMsgPkg struct {
+	pb.RecvBaseDataPkg
+	MsgID uint64
+}
+
+type recvEventPkg struct {
+	pb.RecvBaseDataPkg
+	Event string
+}
+
+type recvEventKeyPkg struct {
+	pb.RecvBaseDataPkg
+	Event    string
+	EventKey string
+	MsgID    uint64
+}
+
+type recvBatchEventPkg struct {
+	pb.RecvBaseDataPkg
+	ID string
+}
+
+func (pkg *recvBatchEventPkg) DedupeKey() string {
+	return pkg.ToUserName + ":" + pkg.ID
+}
+
+func TestDedupeKey(t *testing.T) {
+	base := pb.RecvBaseDataPkg{ToUserName: "toUser", FromUserName: "fromUser", CreateTime: 1348831860}
+
+	tests := []struct {
+		pkg  interface{}
+		want string
+	}{
+		{&recvMsgPkg{RecvBaseDataPkg: base, MsgID: 1234567890123456}, "toUser:1234567890123456"},
+		{&recvMsgPkg{RecvBaseDataPkg: base}, "toUser:fromUser:1348831860"},
+		{&recvEventPkg{RecvBaseDataPkg: base, Event: "subscribe"}, "toUser:fromUser:1348831860:subscribe:"},
+		{&recvEventKeyPkg{RecvBaseDataPkg: base, Event: "CLICK", EventKey: "V1001"}, "toUser:fromUser:1348831860:CLICK:V1001"},
+		{&recvEventKeyPkg{RecvBaseDataPkg: base, Event: "MASSSENDJOBFINISH", MsgID: 1000001625}, "toUser:MASSSENDJOBFINISH:1000001625"},
+		{&recvEventPkg{Event: "subscribe"}, ""},
+		{&recvBatchEventPkg{RecvBaseDataPkg: base, ID: "id1"}, "toUser:id1"},
+		{"not a package", ""},
+	}
+	for _, tt := range tests {
+		if got := pb.DedupeKey(tt.pkg); got != tt.want {
+			t.Errorf("DedupeKey of %#v: want[%s], but actually[%s]", tt.pkg, tt.want, got)
+		}
+	}
+}
+
+func TestDedupeKeyEvents(t *testing.T) {
+	base := pb.RecvBaseDataPkg{ToUserName: "toUser", FromUserName: "fromUser", CreateTime: 1348831860}
+
+	// The events of one user in the same second are not duplicates.
+	subscribe := &recvEventPkg{RecvBaseDataPkg: base, Event: "subscribe"}
+	location := &recvEventPkg{RecvBaseDataPkg: base, Event: "LOCATION"}
+	if pb.DedupeKey(subscribe) == pb.DedupeKey(location) {
+		t.Errorf("DedupeKey: want distinct keys, but actually[%s]", pb.DedupeKey(subscribe))
+	}
+
+	click1 := &recvEventKeyPkg{RecvBaseDataPkg: base, Event: "CLICK", EventKey: "V1001"}
+	click2 := &recvEventKeyPkg{RecvBaseDataPkg: base, Event: "CLICK", EventKey: "V1002"}
+	if pb.DedupeKey(click1) == pb.DedupeKey(click2) {
+		t.Errorf("DedupeKey: want distinct keys, but actually[%s]", pb.DedupeKey(click1))
+	}
+}
+
+func TestPayloadKey(t *testing.T) {
+	base := pb.RecvBaseDataPkg{ToUserName: "toUser", FromUserName: "sys", CreateTime: 1348831860}
+
+	key := pb.PayloadKey(&recvBatchEventPkg{RecvBaseDataPkg: base, ID: "id1"})
+	if !strings.HasPrefix(key, "toUser:") {
+		t.Errorf("PayloadKey: want prefix[toUser:], but actually[%s]", key)
+	}
+	if again := pb.PayloadKey(&recvBatchEventPkg{RecvBaseDataPkg: base, ID: "id1"}); again != key {
+		t.Errorf("PayloadKey of the same payload: want[%s], but actually[%s]", key, again)
+	}
+	if other := pb.PayloadKey(&recvBatchEventPkg{RecvBaseDataPkg: base, ID: "id2"}); other == key {
+		t.Errorf("PayloadKey of another payload: want other than[%s], but actually the same", key)
+	}
+}
+
+func TestMemoryDedupeStore(t *testing.T) {
+	ctx := context.Background()
+	s := pb.NewMemoryDedupeStore()
+
+	if added, _ := s.Add(ctx, "k1", time.Minute); !added {
+		t.Fatal("Add: want[true], but actually[false]")
+	}
+	if added, _ := s.Add(ctx, "k1", time.Minute); added {
+		t.Error("Add again: want[false], but actually[true]")
+	}
+	if _, ok, _ := s.Get(ctx, "k1"); ok {
+		t.Error("Get pending: want[false], but actually[true]")
+	}
+
+	s.Set(ctx, "k1", []byte("reply"), time.Minute)
+	reply, ok, err := s.Get(ctx, "k1")
+	if err != nil || !ok || string(reply) != "reply" {
+		t.Errorf("Get: want[reply true <nil>], but actually[%s %v %v]", reply, ok, err)
+	}
+	if added, _ := s.Add(ctx, "k1", time.Minute); added {
+		t.Error("Add done: want[false], but actually[true]")
+	}
+
+	s.Delete(ctx, "k1")
+	if added, _ := s.Add(ctx, "k1", time.Minute); !added {
+		t.Error("Add deleted: want[true], but actually[false]")
+	}
+
+	// Expired.
+	s.Set(ctx, "k2", []byte{}, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok, _ := s.Get(ctx, "k2"); ok {
+		t.Error("Get expired: want[false], but actually[true]")
+	}
+	if added, _ := s.Add(ctx, "k2", time.Minute); !added {
+		t.Error("Add expired: want[true], but actually[false]")
+	}
+}
@@ -0,0 +1,153 @@
+// Package pb provides the encrypted envelope of wechat messages shared by qy and mp.
+package pb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrInvalidSignature is returned when the signature of a request is invalid.
+var ErrInvalidSignature = errors.New("validate signature error")
+
+// EncryptReqBody is a unmarshall result for below xml data:
+//
+//	<xml>
+//	 <ToUserName><![CDATA[toUser]]</ToUserName>
+//	 <AgentID><![CDATA[toAgentID]]</AgentID>
+//	 <Encrypt><![CDATA[msg_encrypt]]</Encrypt>
+//	</xml>
+//
+// AgentID is sent by qy only.
+type EncryptReqBody struct {
+	ToUserName string
+	AgentID    string
+	Encrypt    string
+}
+
+// EncryptRespBody is a source for marshalling below xml data:
+//
+//	<xml>
+//	 <Encrypt><![CDATA[msg_encrypt]]></Encrypt>
+//	 <MsgSignature><![CDATA[msg_signature]]></MsgSignature>
+//	 <TimeStamp>timestamp</TimeStamp>
+//	 <Nonce><![CDATA[nonce]]></Nonce>
+//	</xml>
+type EncryptRespBody struct {
+	XMLName      xml.Name `xml:"xml"`
+	Encrypt      CDATAText
+	MsgSignature CDATAText
+	TimeStamp    int
+	Nonce        CDATAText
+}
+
+// EncodeMsg returns the origData of msg sent to or from receiverID, which
+// is the appID for mp and the corpID for qy.
+// origData = random(16B) + msg_len(4B) + msg + $receiverID
+func EncodeMsg(msg []byte, receiverID string) ([]byte, error) {
+	origData := make([]byte, randomLen+4, randomLen+4+len(msg)+len(receiverID))
+	if _, err := io.ReadFull(rand.Reader, origData[:randomLen]); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(origData[randomLen:], uint32(len(msg)))
+	return bytes.Join([][]byte{origData, msg, []byte(receiverID)}, nil), nil
+}
+
+// Envelope encrypts, decrypts and signs the messages of one receiver, which
+// is the app of mp or the corp of qy. It is safe for concurrent use.
+type Envelope struct {
+	receiverID string
+	token      string
+	crypter    *Crypter
+}
+
+// NewEnvelope creates an Envelope of receiverID, which is the appID for mp
+// and the corpID for qy, with the token and encodingAESKey of it.
+func NewEnvelope(receiverID, token, encodingAESKey string) (*Envelope, error) {
+	crypter, err := NewCrypter(encodingAESKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{receiverID: receiverID, token: token, crypter: crypter}, nil
+}
+
+// Signature returns the msg_signature of msgEncrypt.
+// msg_signature = sha1(sort(token, timestamp, nonce, msg_encrypt))
+func (e *Envelope) Signature(timestamp, nonce, msgEncrypt string) string {
+	return GenSignature(e.token, timestamp, nonce, msgEncrypt)
+}
+
+// Verify reports whether msgSignature is the one of msgEncrypt.
+func (e *Envelope) Verify(msgSignature, timestamp, nonce, msgEncrypt string) bool {
+	return SignatureEqual(msgSignature, e.Signature(timestamp, nonce, msgEncrypt))
+}
+
+// Encrypt returns msg_encrypt of msg.
+func (e *Envelope) Encrypt(msg []byte) (string, error) {
+	origData, err := EncodeMsg(msg, e.receiverID)
+	if err != nil {
+		return "", err
+	}
+	return e.crypter.Encrypt(origData)
+}
+
+// Decrypt returns the msg in msgEncrypt. It returns an error wrapping
+// ErrAppIDMismatch if msgEncrypt is not of the receiver of e.
+func (e *Envelope) Decrypt(msgEncrypt string) ([]byte, error) {
+	origData, err := e.crypter.Decrypt(msgEncrypt)
+	if err != nil {
+		return nil, err
+	}
+	msg, receiverID, err := DecodeMsg(origData)
+	if err != nil {
+		return nil, err
+	}
+	if err = CheckAppID(receiverID, e.receiverID); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ParseBody parses the encrypted request body.
+func (e *Envelope) ParseBody(body []byte) (*EncryptReqBody, error) {
+	reqBody := &EncryptReqBody{}
+	if err := xml.Unmarshal(body, reqBody); err != nil {
+		return nil, err
+	}
+	return reqBody, nil
+}
+
+// Open parses the encrypted request body, validates its msgSignature and
+// returns the decrypted msg.
+func (e *Envelope) Open(body []byte, msgSignature, timestamp, nonce string) ([]byte, error) {
+	reqBody, err := e.ParseBody(body)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Verify(msgSignature, timestamp, nonce, reqBody.Encrypt) {
+		return nil, ErrInvalidSignature
+	}
+	return e.Decrypt(reqBody.Encrypt)
+}
+
+// Seal returns the encrypted and signed response body of msg.
+func (e *Envelope) Seal(msg []byte) ([]byte, error) {
+	msgEncrypt, err := e.Encrypt(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := GenNonce()
+	timestamp := GenTimestamp()
+	resp := &EncryptRespBody{
+		Encrypt:      String2CDATA(msgEncrypt),
+		MsgSignature: String2CDATA(e.Signature(fmt.Sprintf("%d", timestamp), nonce, msgEncrypt)),
+		TimeStamp:    timestamp,
+		Nonce:        String2CDATA(nonce),
+	}
+	return xml.MarshalIndent(resp, " ", "  ")
+}
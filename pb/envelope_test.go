@@ -0,0 +1,97 @@
+package pb_test
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+const (
+	envelopeCorpID = "wx2f6d0a549c129f06"
+	envelopeToken  = "wechat4go"
+	envelopeKey    = "jRwY6v82amVaTB4eXdjG775NH8ubF6AwauNed88UfGK"
+)
+
+func TestEncodeMsg(t *testing.T) {
+	origData, err := pb.EncodeMsg([]byte("hello"), envelopeCorpID)
+	if err != nil {
+		t.Fatal("EncodeMsg error:", err)
+	}
+	if len(origData) != 16+4+len("hello")+len(envelopeCorpID) {
+		t.Errorf("Length: want[%d], but actually[%d]", 16+4+len("hello")+len(envelopeCorpID), len(origData))
+	}
+
+	msg, corpID, err := pb.DecodeMsg(origData)
+	if err != nil {
+		t.Fatal("DecodeMsg error:", err)
+	}
+	if string(msg) != "hello" || corpID != envelopeCorpID {
+		t.Errorf("DecodeMsg: want[hello %s], but actually[%s %s]", envelopeCorpID, msg, corpID)
+	}
+}
+
+func TestEnvelopeDecryptVector(t *testing.T) {
+	e, err := pb.NewEnvelope(envelopeCorpID, envelopeToken, envelopeKey)
+	if err != nil {
+		t.Fatal("NewEnvelope error:", err)
+	}
+
+	msg, err := e.Decrypt(cryptoVectors[0].msgEncrypt)
+	if err != nil {
+		t.Fatal("Decrypt error:", err)
+	}
+	if !strings.Contains(string(msg), "<FromUserName><![CDATA[baim]]></FromUserName>") {
+		t.Errorf("Decrypt: want the msg from baim, but actually[%s]", msg)
+	}
+
+	other, err := pb.NewEnvelope("wx5823bf96d3bd56c7", envelopeToken, envelopeKey)
+	if err != nil {
+		t.Fatal("NewEnvelope error:", err)
+	}
+	if _, err := other.Decrypt(cryptoVectors[0].msgEncrypt); !errors.Is(err, pb.ErrAppIDMismatch) {
+		t.Errorf("Decrypt of another corp: want[%v], but actually[%v]", pb.ErrAppIDMismatch, err)
+	}
+}
+
+func TestEnvelopeSealOpen(t *testing.T) {
+	e, err := pb.NewEnvelope(envelopeCorpID, envelopeToken, envelopeKey)
+	if err != nil {
+		t.Fatal("NewEnvelope error:", err)
+	}
+
+	body, err := e.Seal([]byte("<xml>hello</xml>"))
+	if err != nil {
+		t.Fatal("Seal error:", err)
+	}
+	respBody := &struct {
+		Encrypt      string
+		MsgSignature string
+		TimeStamp    string
+		Nonce        string
+	}{}
+	if err = xml.Unmarshal(body, respBody); err != nil {
+		t.Fatalf("Xml decoding [%s] error: %s", body, err)
+	}
+
+	// The response body is opened like a request body.
+	msg, err := e.Open(body, respBody.MsgSignature, respBody.TimeStamp, respBody.Nonce)
+	if err != nil {
+		t.Fatal("Open error:", err)
+	}
+	if string(msg) != "<xml>hello</xml>" {
+		t.Errorf("Open: want[%s], but actually[%s]", "<xml>hello</xml>", msg)
+	}
+
+	if _, err = e.Open(body, "78d6123977c8e5ecb255b74ecef385c5a1b5823e", respBody.TimeStamp, respBody.Nonce); err != pb.ErrInvalidSignature {
+		t.Errorf("Open: want[%v], but actually[%v]", pb.ErrInvalidSignature, err)
+	}
+}
+
+func TestNewEnvelopeInvalidKey(t *testing.T) {
+	if _, err := pb.NewEnvelope(envelopeCorpID, envelopeToken, "short"); err == nil {
+		t.Error("NewEnvelope: want an error, but actually nil")
+	}
+}
@@ -0,0 +1,105 @@
+// Package pb provides the error returned by wechat api.
+package pb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Well-known errcodes of wechat api shared by qy and mp.
+const (
+	ErrcodeSystemBusy            = -1
+	ErrcodeOK                    = 0
+	ErrcodeInvalidCredential     = 40001
+	ErrcodeInvalidGrantType      = 40002
+	ErrcodeInvalidOpenID         = 40003
+	ErrcodeInvalidAppID          = 40013 // invalid corpid for qy
+	ErrcodeInvalidAccessToken    = 40014
+	ErrcodeInvalidCode           = 40029
+	ErrcodeInvalidAgentID        = 40056
+	ErrcodeAccessTokenMissing    = 41001
+	ErrcodeAccessTokenExpired    = 42001
+	ErrcodeDailyQuotaExceeded    = 45009 // the frequency limit for qy
+	ErrcodeMinuteQuotaExceeded   = 45011
+	ErrcodeConcurrencyExceeded   = 45033 // qy only
+	ErrcodeResponseCountExceeded = 45047
+	ErrcodeAPIUnauthorized       = 48001
+)
+
+// APIError is the error reported by wechat api, either by a non-zero errcode
+// in the result or by a non-200 http status.
+type APIError struct {
+	Errcode    int
+	Errmsg     string
+	Endpoint   string // The path of the api, such as "/cgi-bin/message/send".
+	StatusCode int    // The http status of the response.
+}
+
+func (e *APIError) Error() string {
+	if e.Errcode == ErrcodeOK {
+		return fmt.Sprintf("wechat api %s: http status %d", e.Endpoint, e.StatusCode)
+	}
+	return fmt.Sprintf("wechat api %s: errcode %d: %s", e.Endpoint, e.Errcode, e.Errmsg)
+}
+
+// IsTokenExpired reports whether err is an APIError meaning that the
+// access token is invalid or expired.
+func IsTokenExpired(err error) bool {
+	var e *APIError
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch e.Errcode {
+	case ErrcodeInvalidCredential, ErrcodeInvalidAccessToken, ErrcodeAccessTokenExpired:
+		return true
+	}
+	return false
+}
+
+// IsRateLimited reports whether err is an APIError meaning that the
+// call exceeds the rate limit or the quota of the api.
+func IsRateLimited(err error) bool {
+	var e *APIError
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch e.Errcode {
+	case ErrcodeDailyQuotaExceeded, ErrcodeMinuteQuotaExceeded, ErrcodeConcurrencyExceeded,
+		ErrcodeResponseCountExceeded:
+		return true
+	}
+	return false
+}
+
+// IsSystemBusy reports whether err is an APIError meaning that wechat
+// platform is busy and the call could be retried later.
+func IsSystemBusy(err error) bool {
+	var e *APIError
+	return errors.As(err, &e) && e.Errcode == ErrcodeSystemBusy
+}
+
+// ErrorCode is the errcode in the result of wechat api. Some apis return it
+// as a string such as "40013" instead of a number, both forms are accepted.
+type ErrorCode int
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *ErrorCode) UnmarshalJSON(data []byte) error {
+	// json.Number accepts both 40013 and "40013".
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid errcode %s", data)
+	}
+	if n == "" {
+		*c = 0
+		return nil
+	}
+
+	v, err := strconv.Atoi(string(n))
+	if err != nil {
+		return fmt.Errorf("invalid errcode %s", data)
+	}
+	*c = ErrorCode(v)
+	return nil
+}
@@ -0,0 +1,73 @@
+package pb_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+func TestAPIErrorHelpers(t *testing.T) {
+	tests := []struct {
+		err         error
+		expired     bool
+		rateLimited bool
+		busy        bool
+	}{
+		{&pb.APIError{Errcode: pb.ErrcodeAccessTokenExpired}, true, false, false},
+		{&pb.APIError{Errcode: pb.ErrcodeInvalidCredential}, true, false, false},
+		{fmt.Errorf("send: %w", &pb.APIError{Errcode: pb.ErrcodeDailyQuotaExceeded}), false, true, false},
+		{&pb.APIError{Errcode: pb.ErrcodeResponseCountExceeded}, false, true, false},
+		{&pb.APIError{Errcode: pb.ErrcodeSystemBusy}, false, false, true},
+		{fmt.Errorf("invalid access_token"), false, false, false},
+		{nil, false, false, false},
+	}
+
+	for _, tt := range tests {
+		if got := pb.IsTokenExpired(tt.err); got != tt.expired {
+			t.Errorf("IsTokenExpired(%v): want[%t], but actually[%t]", tt.err, tt.expired, got)
+		}
+		if got := pb.IsRateLimited(tt.err); got != tt.rateLimited {
+			t.Errorf("IsRateLimited(%v): want[%t], but actually[%t]", tt.err, tt.rateLimited, got)
+		}
+		if got := pb.IsSystemBusy(tt.err); got != tt.busy {
+			t.Errorf("IsSystemBusy(%v): want[%t], but actually[%t]", tt.err, tt.busy, got)
+		}
+	}
+}
+
+func TestAPIErrorString(t *testing.T) {
+	err := &pb.APIError{Errcode: 40013, Errmsg: "invalid corpid", Endpoint: "/cgi-bin/gettoken", StatusCode: 200}
+	want := "wechat api /cgi-bin/gettoken: errcode 40013: invalid corpid"
+	if err.Error() != want {
+		t.Errorf("Error: want[%s], but actually[%s]", want, err.Error())
+	}
+
+	err = &pb.APIError{Endpoint: "/cgi-bin/gettoken", StatusCode: 502}
+	want = "wechat api /cgi-bin/gettoken: http status 502"
+	if err.Error() != want {
+		t.Errorf("Error: want[%s], but actually[%s]", want, err.Error())
+	}
+}
+
+type errorCodeResp struct {
+	Errcode pb.ErrorCode `json:"errcode"`
+}
+
+func TestErrorCodeUnmarshal(t *testing.T) {
+	for _, data := range []string{`{"errcode": 40013}`, `{"errcode": "40013"}`} {
+		r := &errorCodeResp{}
+		if err := json.Unmarshal([]byte(data), r); err != nil {
+			t.Fatalf("Unmarshal %s error: %s", data, err)
+		}
+		if r.Errcode != 40013 {
+			t.Errorf("Errcode of %s: want[%d], but actually[%d]", data, 40013, r.Errcode)
+		}
+	}
+
+	r := &errorCodeResp{}
+	if err := json.Unmarshal([]byte(`{"errcode": "abc"}`), r); err == nil {
+		t.Error("want an error for errcode \"abc\", but actually it returns nil")
+	}
+}
@@ -0,0 +1,242 @@
+// Package pb provides the middlewares for the outbound calls.
+package pb
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Middleware wraps the http.RoundTripper which sends the requests of a
+// Client, so that every outbound call could be logged, traced or metered.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to allow the use of ordinary functions
+// as http.RoundTripper.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// chain wraps rt with middlewares, the first one is the outermost.
+func chain(rt http.RoundTripper, middlewares []Middleware) http.RoundTripper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		rt = middlewares[i](rt)
+	}
+	return rt
+}
+
+// redactedParams are the query parameters carrying credentials.
+var redactedParams = []string{"access_token", "secret", "corpsecret", "code", "refresh_token"}
+
+const redacted = "REDACTED"
+
+// RedactURL returns u as a string, with the values of the credentials in the
+// query, such as access_token and secret, replaced with "REDACTED".
+func RedactURL(u *url.URL) string {
+	query := u.Query()
+	changed := false
+	for _, k := range redactedParams {
+		if _, ok := query[k]; ok {
+			query.Set(k, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return u.String()
+	}
+
+	ru := *u
+	ru.RawQuery = query.Encode()
+	return ru.String()
+}
+
+// redactString replaces the credentials of u found in s with "REDACTED".
+func redactString(s string, u *url.URL) string {
+	query := u.Query()
+	for _, k := range redactedParams {
+		for _, v := range query[k] {
+			if v != "" {
+				s = strings.Replace(s, url.QueryEscape(v), redacted, -1)
+				s = strings.Replace(s, v, redacted, -1)
+			}
+		}
+	}
+	return s
+}
+
+// redactedError is an error whose message has the credentials redacted.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactError redacts the credentials of u in err. *url.Error keeps its type
+// with a redacted URL, so that its methods such as Timeout still work.
+func redactError(err error, u *url.URL) error {
+	if err == nil {
+		return nil
+	}
+	var ue *url.Error
+	if errors.As(err, &ue) && ue == err {
+		return &url.Error{Op: ue.Op, URL: redactString(ue.URL, u), Err: redactError(ue.Err, u)}
+	}
+	msg := err.Error()
+	if rmsg := redactString(msg, u); rmsg != msg {
+		return &redactedError{msg: rmsg, err: err}
+	}
+	return err
+}
+
+// RedactMiddleware redacts the credentials in the query of the request, such
+// as access_token and secret, from the errors returned by the inner round
+// trippers, such as the ones of an egress proxy.
+func RedactMiddleware() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			return resp, redactError(err, req.URL)
+		})
+	}
+}
+
+// LogMiddleware logs every call with logger, with the credentials in the
+// url redacted.
+func LogMiddleware(logger *slog.Logger) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			attrs := []slog.Attr{
+				slog.String("method", req.Method),
+				slog.String("url", RedactURL(req.URL)),
+				slog.Duration("latency", time.Since(start)),
+			}
+			if err != nil {
+				attrs = append(attrs, slog.String("error", redactError(err, req.URL).Error()))
+				logger.LogAttrs(req.Context(), slog.LevelError, "wechat api call failed", attrs...)
+				return resp, err
+			}
+			attrs = append(attrs, slog.Int("status", resp.StatusCode))
+			logger.LogAttrs(req.Context(), slog.LevelInfo, "wechat api call", attrs...)
+			return resp, err
+		})
+	}
+}
+
+// MetricsMiddleware observes the latency of every call in h, keyed by the
+// path of the request.
+func MetricsMiddleware(h *LatencyHistogram) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			h.Observe(req.URL.Path, time.Since(start))
+			return resp, err
+		})
+	}
+}
+
+// DefaultLatencyBounds are the upper bounds of the buckets of a
+// LatencyHistogram created without bounds.
+var DefaultLatencyBounds = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// LatencyHistogram is a histogram of the latencies per endpoint.
+// It is safe for concurrent use.
+type LatencyHistogram struct {
+	bounds []time.Duration
+
+	mu         sync.Mutex
+	histograms map[string]*HistogramSnapshot
+}
+
+// HistogramSnapshot is the state of the histogram of one endpoint.
+// Counts[i] is the number of latencies not greater than Bounds[i], and
+// the last one of Counts is the number of the ones greater than all Bounds.
+type HistogramSnapshot struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// NewLatencyHistogram creates a LatencyHistogram with the bucket upper
+// bounds in ascending order. DefaultLatencyBounds is used if there is none.
+func NewLatencyHistogram(bounds ...time.Duration) *LatencyHistogram {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBounds
+	}
+	return &LatencyHistogram{
+		bounds:     append([]time.Duration(nil), bounds...),
+		histograms: make(map[string]*HistogramSnapshot),
+	}
+}
+
+// Observe adds latency d of endpoint.
+func (h *LatencyHistogram) Observe(endpoint string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.histograms[endpoint]
+	if !ok {
+		s = &HistogramSnapshot{Bounds: h.bounds, Counts: make([]uint64, len(h.bounds)+1)}
+		h.histograms[endpoint] = s
+	}
+
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	s.Counts[i]++
+	s.Count++
+	s.Sum += d
+}
+
+// Snapshot returns a copy of the histogram of endpoint.
+func (h *LatencyHistogram) Snapshot(endpoint string) HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.histograms[endpoint]
+	if !ok {
+		return HistogramSnapshot{Bounds: h.Bounds(), Counts: make([]uint64, len(h.bounds)+1)}
+	}
+	snapshot := *s
+	snapshot.Bounds = h.Bounds()
+	snapshot.Counts = append([]uint64(nil), s.Counts...)
+	return snapshot
+}
+
+// Bounds returns a copy of the bucket upper bounds of h.
+func (h *LatencyHistogram) Bounds() []time.Duration {
+	return append([]time.Duration(nil), h.bounds...)
+}
+
+// Endpoints returns the endpoints observed.
+func (h *LatencyHistogram) Endpoints() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	endpoints := make([]string, 0, len(h.histograms))
+	for k := range h.histograms {
+		endpoints = append(endpoints, k)
+	}
+	return endpoints
+}
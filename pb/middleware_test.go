@@ -0,0 +1,126 @@
+package pb_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+func TestRedactURL(t *testing.T) {
+	u, _ := url.Parse("https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=wxfd4448417439fd3x&corpsecret=" + secret)
+	want := "https://qyapi.weixin.qq.com/cgi-bin/gettoken?corpid=wxfd4448417439fd3x&corpsecret=REDACTED"
+	if got := pb.RedactURL(u); got != want {
+		t.Errorf("RedactURL: want[%s], but actually[%s]", want, got)
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+	}))
+	defer ts.Close()
+
+	var order []string
+	built := 0
+	trace := func(name string) pb.Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			built++
+			return pb.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	logBuf := &bytes.Buffer{}
+	h := pb.NewLatencyHistogram()
+	c := pb.NewClient(ts.URL)
+	c.TokenSource = &countingTokenSource{token: accessToken}
+	c.Middlewares = []pb.Middleware{
+		trace("outer"),
+		pb.LogMiddleware(slog.New(slog.NewJSONHandler(logBuf, nil))),
+		pb.MetricsMiddleware(h),
+		trace("inner"),
+	}
+
+	if err := pb.SendMsg(c, postPath, nil, &pb.SendMsgTextPkg{ToUser: "tonybai"}); err != nil {
+		t.Fatal("SendMsg error:", err)
+	}
+
+	if strings.Join(order, ",") != "outer,inner" {
+		t.Errorf("Order: want[outer,inner], but actually[%s]", strings.Join(order, ","))
+	}
+
+	log := logBuf.String()
+	if strings.Contains(log, accessToken) || !strings.Contains(log, "access_token=REDACTED") {
+		t.Errorf("Log: want access_token redacted, but actually[%s]", log)
+	}
+	if !strings.Contains(log, `"status":200`) {
+		t.Errorf("Log: want status 200, but actually[%s]", log)
+	}
+
+	s := h.Snapshot(postPath)
+	if s.Count != 1 || len(s.Counts) != len(pb.DefaultLatencyBounds)+1 {
+		t.Errorf("Histogram: want 1 latency in %d buckets, but actually%+v", len(pb.DefaultLatencyBounds)+1, s)
+	}
+
+	// The chain is built once.
+	if err := pb.SendMsg(c, postPath, nil, &pb.SendMsgTextPkg{ToUser: "tonybai"}); err != nil {
+		t.Fatal("SendMsg error:", err)
+	}
+	if built != 2 {
+		t.Errorf("Built: want[%d], but actually[%d]", 2, built)
+	}
+}
+
+func TestRedactMiddleware(t *testing.T) {
+	failing := func(next http.RoundTripper) http.RoundTripper {
+		return pb.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("proxy refused " + req.URL.String())
+		})
+	}
+
+	c := pb.NewClient("http://127.0.0.1")
+	c.TokenSource = &countingTokenSource{token: accessToken}
+	c.Middlewares = []pb.Middleware{pb.RedactMiddleware(), failing}
+
+	err := pb.SendMsg(c, postPath, nil, &pb.SendMsgTextPkg{ToUser: "tonybai"})
+	if err == nil || strings.Contains(err.Error(), accessToken) {
+		t.Errorf("Err: want an error with access_token redacted, but actually[%v]", err)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	h := pb.NewLatencyHistogram(10*time.Millisecond, 100*time.Millisecond)
+	h.Observe(postPath, 5*time.Millisecond)
+	h.Observe(postPath, 10*time.Millisecond)
+	h.Observe(postPath, 50*time.Millisecond)
+	h.Observe(postPath, time.Second)
+
+	s := h.Snapshot(postPath)
+	want := []uint64{2, 1, 1}
+	for i := range want {
+		if s.Counts[i] != want[i] {
+			t.Errorf("Counts: want%v, but actually%v", want, s.Counts)
+			break
+		}
+	}
+	if s.Sum != 1065*time.Millisecond {
+		t.Errorf("Sum: want[%s], but actually[%s]", 1065*time.Millisecond, s.Sum)
+	}
+
+	// The buckets could not be changed by the snapshot.
+	s.Bounds[0] = time.Hour
+	h.Observe(postPath, 5*time.Millisecond)
+	if s = h.Snapshot(postPath); s.Bounds[0] != 10*time.Millisecond || s.Counts[0] != 3 {
+		t.Errorf("Bounds and Counts: want[%s 3], but actually[%s %d]", 10*time.Millisecond, s.Bounds[0], s.Counts[0])
+	}
+}
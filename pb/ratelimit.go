@@ -0,0 +1,169 @@
+// Package pb provides client-side rate limiting and quota accounting.
+package pb
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter limits the rate of the calls per endpoint with token buckets.
+// It is safe for concurrent use.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	limits  map[string]limit
+	buckets map[string]*bucket
+}
+
+type limit struct {
+	rate  float64
+	burst int
+}
+
+// bucket holds the tokens of one endpoint. tokens may be negative when
+// the tokens are reserved by waiting callers.
+type bucket struct {
+	limit
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a Limiter allowing rate calls per second with bursts of
+// at most burst calls for every endpoint. A rate not greater than zero means
+// no limit.
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   burst,
+		limits:  make(map[string]limit),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// SetLimit overrides the rate and burst of endpoint, such as
+// "/cgi-bin/message/send".
+func (l *Limiter) SetLimit(endpoint string, rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limits[endpoint] = limit{rate: rate, burst: burst}
+	delete(l.buckets, endpoint)
+}
+
+// Wait blocks until a call to endpoint is allowed or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, endpoint string) error {
+	l.mu.Lock()
+	b := l.bucketLocked(endpoint)
+	if b.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+
+	// Reserve one token, and wait for it if it is not there yet.
+	b.tokens--
+	if b.tokens >= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Give back the reserved token.
+		l.mu.Lock()
+		b.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// bucketLocked returns the bucket of endpoint. l.mu must be held.
+func (l *Limiter) bucketLocked(endpoint string) *bucket {
+	if b, ok := l.buckets[endpoint]; ok {
+		return b
+	}
+
+	lim, ok := l.limits[endpoint]
+	if !ok {
+		lim = limit{rate: l.rate, burst: l.burst}
+	}
+	if lim.burst < 1 {
+		lim.burst = 1
+	}
+	b := &bucket{limit: lim, tokens: float64(lim.burst), last: time.Now()}
+	l.buckets[endpoint] = b
+	return b
+}
+
+// quotaZone is the time zone in which the daily quotas of wechat api
+// are reset at midnight.
+var quotaZone = time.FixedZone("CST", 8*60*60)
+
+// QuotaCounter counts the calls made per endpoint in the current day, the
+// counts are reset at midnight of Beijing time just like the daily quotas of
+// wechat api. It is safe for concurrent use.
+type QuotaCounter struct {
+	mu     sync.Mutex
+	day    string
+	counts map[string]int
+}
+
+// NewQuotaCounter creates a QuotaCounter with zero counts.
+func NewQuotaCounter() *QuotaCounter {
+	return &QuotaCounter{counts: make(map[string]int)}
+}
+
+// Add counts one call to endpoint and returns the count of today.
+func (q *QuotaCounter) Add(endpoint string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.resetLocked()
+	q.counts[endpoint]++
+	return q.counts[endpoint]
+}
+
+// Count returns the count of the calls to endpoint today.
+func (q *QuotaCounter) Count(endpoint string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.resetLocked()
+	return q.counts[endpoint]
+}
+
+// Counts returns the counts of all the endpoints today.
+func (q *QuotaCounter) Counts() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.resetLocked()
+	counts := make(map[string]int, len(q.counts))
+	for k, v := range q.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+// resetLocked clears the counts of the previous day. q.mu must be held.
+func (q *QuotaCounter) resetLocked() {
+	day := time.Now().In(quotaZone).Format("2006-01-02")
+	if day != q.day {
+		q.day = day
+		q.counts = make(map[string]int)
+	}
+}
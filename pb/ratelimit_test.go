@@ -0,0 +1,130 @@
+package pb_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+func TestLimiterWait(t *testing.T) {
+	l := pb.NewLimiter(0, 0)
+	l.SetLimit(postPath, 20, 2)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(context.Background(), postPath); err != nil {
+			t.Fatal("Wait error:", err)
+		}
+	}
+	// 2 calls are allowed by the burst, the other 2 wait for 50ms each.
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("Elapsed: want at least[%s], but actually[%s]", 90*time.Millisecond, d)
+	}
+
+	// The other endpoints are not limited.
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		l.Wait(context.Background(), accessTokenFetchPath)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("Elapsed: want at most[%s], but actually[%s]", 50*time.Millisecond, d)
+	}
+}
+
+func TestLimiterWaitCanceled(t *testing.T) {
+	l := pb.NewLimiter(1, 1)
+	l.Wait(context.Background(), postPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, postPath); err != context.DeadlineExceeded {
+		t.Errorf("Err: want[%v], but actually[%v]", context.DeadlineExceeded, err)
+	}
+}
+
+func TestQuotaCounter(t *testing.T) {
+	q := pb.NewQuotaCounter()
+	q.Add(postPath)
+	q.Add(postPath)
+	q.Add(accessTokenFetchPath)
+
+	if n := q.Count(postPath); n != 2 {
+		t.Errorf("Count: want[%d], but actually[%d]", 2, n)
+	}
+	counts := q.Counts()
+	if len(counts) != 2 || counts[accessTokenFetchPath] != 1 {
+		t.Errorf("Counts: want[map[%s:1 %s:2]], but actually%v", accessTokenFetchPath, postPath, counts)
+	}
+}
+
+func TestClientRateLimited(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.Write([]byte(`{"errcode": 45011, "errmsg": "api minute-quota reach limit"}`))
+			return
+		}
+		w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+	}))
+	defer ts.Close()
+
+	var limited []int
+	c := pb.NewClient(ts.URL)
+	c.Quota = pb.NewQuotaCounter()
+	c.OnRateLimited = func(ctx context.Context, err *pb.APIError) {
+		limited = append(limited, err.Errcode)
+	}
+	c.RateLimitRetries = 2
+	c.RateLimitBackoff = time.Millisecond
+
+	if err := pb.SendMsg(c, postPath, nil, &pb.SendMsgTextPkg{ToUser: "tonybai"}); err != nil {
+		t.Fatal("SendMsg error:", err)
+	}
+	if len(limited) != 2 || limited[0] != pb.ErrcodeMinuteQuotaExceeded {
+		t.Errorf("Limited: want[%d %d], but actually%v", 45011, 45011, limited)
+	}
+	if n := c.Quota.Count(postPath); n != 3 {
+		t.Errorf("Count: want[%d], but actually[%d]", 3, n)
+	}
+
+	// No more retries are left.
+	calls = 0
+	c.RateLimitRetries = 1
+	err := pb.SendMsg(c, postPath, nil, &pb.SendMsgTextPkg{ToUser: "tonybai"})
+	if !pb.IsRateLimited(err) {
+		t.Errorf("Err: want a rate limited error, but actually[%v]", err)
+	}
+}
+
+func TestClientRateLimitedNoRetry(t *testing.T) {
+	for _, errcode := range []int{pb.ErrcodeDailyQuotaExceeded, pb.ErrcodeResponseCountExceeded} {
+		calls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			fmt.Fprintf(w, `{"errcode": %d, "errmsg": "quota reach limit"}`, errcode)
+		}))
+
+		limited := 0
+		c := pb.NewClient(ts.URL)
+		c.OnRateLimited = func(ctx context.Context, err *pb.APIError) {
+			limited++
+		}
+		c.RateLimitRetries = 3
+		c.RateLimitBackoff = time.Millisecond
+
+		err := pb.SendMsg(c, postPath, nil, &pb.SendMsgTextPkg{ToUser: "tonybai"})
+		ts.Close()
+		if !pb.IsRateLimited(err) {
+			t.Errorf("Err of %d: want a rate limited error, but actually[%v]", errcode, err)
+		}
+		if calls != 1 || limited != 1 {
+			t.Errorf("Calls and OnRateLimited of %d: want[1 1], but actually[%d %d]", errcode, calls, limited)
+		}
+	}
+}
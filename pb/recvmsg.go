@@ -18,10 +18,21 @@ type CDATAText struct {
 	Text string `xml:",innerxml"`
 }
 
+// String2CDATA returns v in a CDATA section. A "]]>" in v is split into
+// two sections, so that it could not end the section early.
 func String2CDATA(v string) CDATAText {
-	return CDATAText{"<![CDATA[" + v + "]]>"}
+	return CDATAText{"<![CDATA[" + strings.Replace(v, "]]>", cdataEndSplit, -1) + "]]>"}
 }
 
+// CDATA2String returns the text in c made by String2CDATA.
+func CDATA2String(c CDATAText) string {
+	v := strings.TrimSuffix(strings.TrimPrefix(c.Text, "<![CDATA["), "]]>")
+	return strings.Replace(v, cdataEndSplit, "]]>", -1)
+}
+
+// cdataEndSplit is the "]]>" split into two CDATA sections.
+const cdataEndSplit = "]]]]><![CDATA[>"
+
 // RecvBaseDataPkg is the base msg struct for qy and mp receive message.
 // it contains the fields shared by qy and mp receive message.
 type RecvBaseDataPkg struct {
@@ -41,9 +52,65 @@ type RecvRespBaseDataPkg struct {
 	MsgType      CDATAText
 }
 
+// ScanCodeInfo is the scan result of a scancode_push or scancode_waitmsg
+// menu event.
+type ScanCodeInfo struct {
+	ScanType   string
+	ScanResult string
+}
+
+// PicItem is a picture sent by a pic menu event.
+type PicItem struct {
+	PicMd5Sum string
+}
+
+// SendPicsInfo is the pictures sent by a pic_sysphoto, pic_photo_or_album
+// or pic_weixin menu event.
+type SendPicsInfo struct {
+	Count   int
+	PicList []PicItem `xml:"PicList>item"`
+}
+
+// SendLocationInfo is the location sent by a location_select menu event.
+type SendLocationInfo struct {
+	LocX    float64 `xml:"Location_X"`
+	LocY    float64 `xml:"Location_Y"`
+	Scale   int
+	Label   string
+	Poiname string
+}
+
+// RecvPkg is implemented by the received message packages of qy and mp,
+// which embed RecvBaseDataPkg.
+type RecvPkg interface {
+	RecvBase() *RecvBaseDataPkg
+}
+
+// RecvBase returns p itself, so that the packages embedding RecvBaseDataPkg
+// implement RecvPkg.
+func (p *RecvBaseDataPkg) RecvBase() *RecvBaseDataPkg {
+	return p
+}
+
+// NewRecvRespBaseDataPkg creates the base of the reply to recv with msgType.
+// The reply is sent from the receiver of recv to its sender at now.
+func NewRecvRespBaseDataPkg(recv RecvPkg, msgType string) RecvRespBaseDataPkg {
+	base := recv.RecvBase()
+	return RecvRespBaseDataPkg{
+		ToUserName:   String2CDATA(base.FromUserName),
+		FromUserName: String2CDATA(base.ToUserName),
+		CreateTime:   GenTimestamp(),
+		MsgType:      String2CDATA(msgType),
+	}
+}
+
 // RecvHandler is a interface for qy and mp package to implement.
+// signature and msgSignature passed to Parse are the signature and the
+// msg_signature of the request, either of which may be absent: mp signs
+// the requests by signature, and by msg_signature too if they are
+// encrypted, while qy signs them by msg_signature only.
 type RecvHandler interface {
-	Parse(bodyText []byte, signature, timestamp, nonce, encryptType string) (interface{}, error)
+	Parse(bodyText []byte, signature, msgSignature, timestamp, nonce, encryptType string) (interface{}, error)
 	Response(msg []byte, encryptType string) ([]byte, error)
 }
 
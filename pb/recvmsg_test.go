@@ -16,6 +16,38 @@ func TestString2CDATA(t *testing.T) {
 	}
 }
 
+func TestCDATA2String(t *testing.T) {
+	if got := pb.CDATA2String(pb.String2CDATA("toUser")); got != "toUser" {
+		t.Errorf("Want [%s], but actual[%s]", "toUser", got)
+	}
+}
+
+func TestString2CDATAEscape(t *testing.T) {
+	v := "a]]><MsgType>news</MsgType><![CDATA[b"
+	field := pb.String2CDATA(v)
+
+	data, err := xml.Marshal(&struct {
+		XMLName xml.Name `xml:"xml"`
+		Content pb.CDATAText
+	}{Content: field})
+	if err != nil {
+		t.Fatal("Xml marshalling error:", err)
+	}
+	got := &struct {
+		Content string
+		MsgType string
+	}{}
+	if err = xml.Unmarshal(data, got); err != nil {
+		t.Fatalf("Xml decoding [%s] error: %s", data, err)
+	}
+	if got.Content != v || got.MsgType != "" {
+		t.Errorf("Content and MsgType: want[%s ], but actually[%s %s]", v, got.Content, got.MsgType)
+	}
+	if back := pb.CDATA2String(field); back != v {
+		t.Errorf("CDATA2String: want[%s], but actually[%s]", v, back)
+	}
+}
+
 func TestParseRecvBaseDataPkg(t *testing.T) {
 	var data = &pb.RecvBaseDataPkg{}
 	var pkg = `
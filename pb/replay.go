@@ -0,0 +1,142 @@
+// Package pb provides the replay protection of callback requests.
+package pb
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// DefaultMaxSkew is the default difference allowed between the timestamp
+// of a callback request and now.
+const DefaultMaxSkew = 5 * time.Minute
+
+var (
+	// ErrStaleTimestamp is returned by RecvHandler.Parse when the
+	// timestamp of the request is out of the skew window.
+	ErrStaleTimestamp = errors.New("timestamp out of the skew window")
+
+	// ErrReplayedNonce is returned by RecvHandler.Parse when the nonce of
+	// the request is seen recently.
+	ErrReplayedNonce = errors.New("nonce replayed")
+)
+
+// NonceCache remembers the nonces of the recent callback requests. It could
+// be shared by the instances of a cluster. MemoryDedupeStore, and any other
+// DedupeStore, could be used as a NonceCache.
+type NonceCache interface {
+	// Add adds key for ttl if it is absent, and reports whether it is added.
+	Add(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// ReplayGuard rejects the replayed callback requests by the timestamp and
+// the nonce of them.
+type ReplayGuard struct {
+	// MaxSkew is the difference allowed between the timestamp of a request
+	// and now. DefaultMaxSkew is used if it is zero.
+	MaxSkew time.Duration
+
+	// Nonces remembers the nonces within the skew window. The nonces are
+	// not checked if it is nil.
+	Nonces NonceCache
+
+	// Scope separates the nonces of the receivers sharing Nonces, such as
+	// the apps of a cluster. mp and qy set it to the app id or corp id.
+	Scope string
+}
+
+// WithScope returns a copy of g with scope, or nil if g is nil.
+func (g *ReplayGuard) WithScope(scope string) *ReplayGuard {
+	if g == nil {
+		return nil
+	}
+	scoped := *g
+	scoped.Scope = scope
+	return &scoped
+}
+
+// Check returns an error if the request with timestamp and nonce is out of
+// the skew window or is seen recently. It should be called after the
+// signature of the request is validated.
+func (g *ReplayGuard) Check(timestamp, nonce string) error {
+	if err := g.checkTimestamp(timestamp); err != nil {
+		return err
+	}
+	return g.checkNonce(timestamp, nonce)
+}
+
+func (g *ReplayGuard) maxSkew() time.Duration {
+	if g.MaxSkew == 0 {
+		return DefaultMaxSkew
+	}
+	return g.MaxSkew
+}
+
+// checkTimestamp returns ErrStaleTimestamp if timestamp is out of the skew
+// window.
+func (g *ReplayGuard) checkTimestamp(timestamp string) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	skew := time.Since(time.Unix(sec, 0))
+	if skew > g.maxSkew() || skew < -g.maxSkew() {
+		return ErrStaleTimestamp
+	}
+	return nil
+}
+
+// checkNonce returns ErrReplayedNonce if nonce with timestamp is seen
+// recently.
+func (g *ReplayGuard) checkNonce(timestamp, nonce string) error {
+	if g.Nonces == nil {
+		return nil
+	}
+	// A timestamp older than the skew window is rejected by checkTimestamp,
+	// so the nonce needs to be remembered for the window on both sides only.
+	added, err := g.Nonces.Add(context.Background(), "nonce:"+g.Scope+":"+timestamp+":"+nonce, 2*g.maxSkew())
+	if err != nil {
+		return err
+	}
+	if !added {
+		return ErrReplayedNonce
+	}
+	return nil
+}
+
+// RecvOptions is the options of the RecvHandler created by mp.NewRecvHandler
+// and qy.NewRecvHandler.
+type RecvOptions struct {
+	// Guard checks the timestamp and nonce of the requests if it is not
+	// nil. mp.NewServer and qy.NewServer pass it to Server.Guard instead,
+	// and check the url verification requests with it too.
+	Guard *ReplayGuard
+}
+
+// RecvOption sets an option of RecvOptions.
+type RecvOption func(*RecvOptions)
+
+// WithReplayGuard rejects the requests with a timestamp out of maxSkew
+// from now, and the requests whose nonce is in nonces, which could be nil
+// to check the timestamp only.
+func WithReplayGuard(maxSkew time.Duration, nonces NonceCache) RecvOption {
+	return func(o *RecvOptions) {
+		o.Guard = &ReplayGuard{MaxSkew: maxSkew, Nonces: nonces}
+	}
+}
+
+// NewRecvOptions returns the RecvOptions set by opts.
+func NewRecvOptions(opts ...RecvOption) RecvOptions {
+	var o RecvOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// SignatureEqual compares the signatures in constant time.
+func SignatureEqual(signature, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(signature), []byte(want)) == 1
+}
@@ -0,0 +1,77 @@
+package pb_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+func TestReplayGuard(t *testing.T) {
+	g := &pb.ReplayGuard{MaxSkew: time.Minute, Nonces: pb.NewMemoryDedupeStore()}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	if err := g.Check(now, "1326298654"); err != nil {
+		t.Fatal("Check error:", err)
+	}
+	if err := g.Check(now, "1326298654"); err != pb.ErrReplayedNonce {
+		t.Errorf("Check replayed: want[%v], but actually[%v]", pb.ErrReplayedNonce, err)
+	}
+	if err := g.Check(now, "1326298655"); err != nil {
+		t.Errorf("Check another nonce: want[<nil>], but actually[%v]", err)
+	}
+
+	stale := []string{
+		strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10),
+		strconv.FormatInt(time.Now().Add(2*time.Minute).Unix(), 10),
+		"1426139593",
+		"not a timestamp",
+	}
+	for _, timestamp := range stale {
+		if err := g.Check(timestamp, "1326298656"); err != pb.ErrStaleTimestamp {
+			t.Errorf("Check %s: want[%v], but actually[%v]", timestamp, pb.ErrStaleTimestamp, err)
+		}
+	}
+}
+
+func TestReplayGuardScope(t *testing.T) {
+	nonces := pb.NewMemoryDedupeStore()
+	g1 := (&pb.ReplayGuard{Nonces: nonces}).WithScope("wx0123456789abcdef")
+	g2 := (&pb.ReplayGuard{Nonces: nonces}).WithScope("wxfedcba9876543210")
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// The receivers sharing the nonces do not reject the requests of each other.
+	if err := g1.Check(now, "1326298654"); err != nil {
+		t.Fatal("Check error:", err)
+	}
+	if err := g2.Check(now, "1326298654"); err != nil {
+		t.Errorf("Check of another scope: want[<nil>], but actually[%v]", err)
+	}
+	if err := g1.Check(now, "1326298654"); err != pb.ErrReplayedNonce {
+		t.Errorf("Check replayed: want[%v], but actually[%v]", pb.ErrReplayedNonce, err)
+	}
+
+	if g := (*pb.ReplayGuard)(nil).WithScope("wx0123456789abcdef"); g != nil {
+		t.Errorf("WithScope of nil: want[<nil>], but actually[%v]", g)
+	}
+}
+
+func TestReplayGuardNoNonces(t *testing.T) {
+	g := &pb.ReplayGuard{}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	for i := 0; i < 2; i++ {
+		if err := g.Check(now, "1326298654"); err != nil {
+			t.Errorf("Check: want[<nil>], but actually[%v]", err)
+		}
+	}
+}
+
+func TestSignatureEqual(t *testing.T) {
+	if !pb.SignatureEqual("78d6123977c8e5ecb255b74ecef385c5a1b5823f", "78d6123977c8e5ecb255b74ecef385c5a1b5823f") {
+		t.Error("want SignatureEqual return true, but actually it returns false")
+	}
+	if pb.SignatureEqual("78d6123977c8e5ecb255b74ecef385c5a1b5823e", "78d6123977c8e5ecb255b74ecef385c5a1b5823f") {
+		t.Error("want SignatureEqual return false, but actually it returns true")
+	}
+}
@@ -0,0 +1,29 @@
+// Package pb provides the passive reply messages shared by qy and mp.
+package pb
+
+// RespMedia is the media of an image or voice reply.
+type RespMedia struct {
+	MediaID CDATAText `xml:"MediaId"`
+}
+
+// RespVideo is the video of a video reply.
+type RespVideo struct {
+	MediaID     CDATAText `xml:"MediaId"`
+	Title       CDATAText
+	Description CDATAText
+}
+
+// RespArticle is an article in a news reply.
+type RespArticle struct {
+	Title       CDATAText
+	Description CDATAText
+	PicURL      CDATAText `xml:"PicUrl"`
+	URL         CDATAText `xml:"Url"`
+}
+
+// RecvRespNewsDataPkg is a News reply to the received message.
+type RecvRespNewsDataPkg struct {
+	RecvRespBaseDataPkg
+	ArticleCount int
+	Articles     []RespArticle `xml:"Articles>item"`
+}
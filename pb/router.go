@@ -0,0 +1,109 @@
+// Package pb provides the message router for qy and mp.
+package pb
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// Router is a MsgHandler which dispatches the received message packages to
+// the handlers registered by the type of the package, the event type and
+// the event key. mp.Router and qy.Router add the typed registrations on it.
+//
+// A package is dispatched to the first handler found in order of:
+// the handler of its event and event key, the handler of its event,
+// the handler of its type, and the default handler. The event and event
+// key are read from the Event and EventKey fields of the package.
+type Router struct {
+	mu        sync.RWMutex
+	types     map[reflect.Type]MsgHandler
+	events    map[string]MsgHandler
+	eventKeys map[eventKey]MsgHandler
+	fallback  MsgHandler
+}
+
+type eventKey struct {
+	event string
+	key   string
+}
+
+// NewRouter creates an empty Router.
+func NewRouter() *Router {
+	return &Router{
+		types:     make(map[reflect.Type]MsgHandler),
+		events:    make(map[string]MsgHandler),
+		eventKeys: make(map[eventKey]MsgHandler),
+	}
+}
+
+// HandleType registers h for the packages of the same type as pkg,
+// such as (*mp.RecvTextDataPkg)(nil).
+func (r *Router) HandleType(pkg interface{}, h MsgHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[reflect.TypeOf(pkg)] = h
+}
+
+// HandleEventKey registers h for the event packages of event with key,
+// such as the click of a menu item.
+func (r *Router) HandleEventKey(event, key string, h MsgHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.eventKeys[eventKey{event, key}] = h
+}
+
+// OnEvent registers h for the event packages of event.
+func (r *Router) OnEvent(event string, h func(ctx context.Context, pkg interface{}) Reply) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[event] = MsgHandlerFunc(h)
+}
+
+// Default registers h for the packages which no other handler is
+// registered for. Without it, such packages get no reply.
+func (r *Router) Default(h func(ctx context.Context, pkg interface{}) Reply) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = MsgHandlerFunc(h)
+}
+
+// ServeMsg implements MsgHandler.
+func (r *Router) ServeMsg(ctx context.Context, pkg interface{}) Reply {
+	if h := r.handler(pkg); h != nil {
+		return h.ServeMsg(ctx, pkg)
+	}
+	return nil
+}
+
+func (r *Router) handler(pkg interface{}) MsgHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if event, ok := stringField(pkg, "Event"); ok {
+		key, _ := stringField(pkg, "EventKey")
+		if h, ok := r.eventKeys[eventKey{event, key}]; ok {
+			return h
+		}
+		if h, ok := r.events[event]; ok {
+			return h
+		}
+	}
+	if h, ok := r.types[reflect.TypeOf(pkg)]; ok {
+		return h
+	}
+	return r.fallback
+}
+
+// stringField returns the string field name of the struct pointed by pkg.
+func stringField(pkg interface{}, name string) (string, bool) {
+	v := reflect.ValueOf(pkg)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return "", false
+	}
+	f := v.Elem().FieldByName(name)
+	if !f.IsValid() || f.Kind() != reflect.String {
+		return "", false
+	}
+	return f.String(), true
+}
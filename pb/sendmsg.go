@@ -1,11 +1,8 @@
 package pb
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
-	"io/ioutil"
-	"net/http"
+	"context"
+	"net/url"
 )
 
 type TextContent struct {
@@ -23,48 +20,24 @@ type MediaID struct {
 }
 
 type SendMsgImagePkg struct {
-	ToUser  string  `json:"touser, omitempty"`
+	ToUser  string  `json:"touser,omitempty"`
 	MsgType string  `json:"msgtype"`
 	Image   MediaID `json:"image"`
 }
 
+// SendMsgRespPkg stores the result of message sending.
+//
+// Deprecated: SendMsg returns the error result as an *APIError.
 type SendMsgRespPkg struct {
 	Errcode int    `json:"errcode"`
 	Errmsg  string `json:"errmsg"`
 }
 
-func SendMsg(requestLine string, pkg interface{}) error {
-	reqBody, err := json.MarshalIndent(pkg, " ", "  ")
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest("POST", requestLine, bytes.NewReader(reqBody))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json; encoding=utf-8")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	rPkg := &SendMsgRespPkg{}
-	err = json.Unmarshal(respBody, rPkg)
-	if err != nil {
-		return err
-	}
-
-	if rPkg.Errcode != 0 {
-		return errors.New(rPkg.Errmsg)
-	}
+func SendMsg(c *Client, path string, query url.Values, pkg interface{}) error {
+	return SendMsgContext(context.Background(), c, path, query, pkg)
+}
 
-	return nil
+// SendMsgContext is like SendMsg with a context.
+func SendMsgContext(ctx context.Context, c *Client, path string, query url.Values, pkg interface{}) error {
+	return c.CallJSON(ctx, path, query, pkg, nil)
 }
@@ -1,18 +1,21 @@
 package pb_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/bigwhite/gowechat/pb"
 )
 
 const (
-	postURL = "http://127.0.0.1:9001/cgi-bin/testpost"
+	postPath = "/cgi-bin/testpost"
 )
 
 func postHandler(w http.ResponseWriter, r *http.Request) {
@@ -51,10 +54,12 @@ func postHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(resp))
 }
 
-func setup() {
-	// Create a server for msg send test.
-	http.HandleFunc("/cgi-bin/testpost", postHandler)
-	http.ListenAndServe(":9001", nil)
+func setup() (*httptest.Server, *pb.Client) {
+	// Create a stand-in server for msg send test.
+	mux := http.NewServeMux()
+	mux.HandleFunc(postPath, postHandler)
+	ts := httptest.NewServer(mux)
+	return ts, pb.NewClient(ts.URL)
 }
 
 func TestSendMsg(t *testing.T) {
@@ -64,14 +69,101 @@ func TestSendMsg(t *testing.T) {
 		Text:    pb.TextContent{"hello body"},
 	}
 
-	err := pb.SendMsg(postURL, pkg)
+	ts, c := setup()
+	defer ts.Close()
+
+	err := pb.SendMsg(c, postPath, nil, pkg)
 	if err != nil {
 		t.Fatal("SendMsg error:", err)
 	}
 }
 
-func TestMain(m *testing.M) {
-	go setup()
-	go setupForAccessToken()
-	os.Exit(m.Run())
+type countingTokenSource struct {
+	token     string
+	refreshed int
+}
+
+func (s *countingTokenSource) Token(ctx context.Context) (string, error) {
+	return s.token, nil
+}
+
+func (s *countingTokenSource) Refresh(ctx context.Context, stale string) (string, error) {
+	s.refreshed++
+	s.token = accessToken
+	return s.token, nil
+}
+
+func TestSendMsgTokenExpired(t *testing.T) {
+	var tokens []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("access_token")
+		tokens = append(tokens, token)
+		if token != accessToken {
+			w.Write([]byte(`{"errcode": 42001, "errmsg": "access_token expired"}`))
+			return
+		}
+		w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+	}))
+	defer ts.Close()
+
+	src := &countingTokenSource{token: "accesstoken000000"}
+	c := pb.NewClient(ts.URL)
+	c.TokenSource = src
+
+	pkg := &pb.SendMsgTextPkg{
+		ToUser:  "tonybai",
+		MsgType: "text",
+		Text:    pb.TextContent{Content: "hello body"},
+	}
+	if err := pb.SendMsg(c, postPath, nil, pkg); err != nil {
+		t.Fatal("SendMsg error:", err)
+	}
+
+	if src.refreshed != 1 {
+		t.Errorf("Refreshed: want[%d], but actually[%d]", 1, src.refreshed)
+	}
+	want := []string{"accesstoken000000", accessToken}
+	if fmt.Sprint(tokens) != fmt.Sprint(want) {
+		t.Errorf("Tokens: want%v, but actually%v", want, tokens)
+	}
+}
+
+func TestSendMsgTokenInvalidRetryOnce(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"errcode": 40001, "errmsg": "invalid credential"}`))
+	}))
+	defer ts.Close()
+
+	c := pb.NewClient(ts.URL)
+	c.TokenSource = &countingTokenSource{token: "accesstoken000000"}
+
+	err := pb.SendMsg(c, postPath, nil, &pb.SendMsgTextPkg{ToUser: "tonybai"})
+	if !pb.IsTokenExpired(err) {
+		t.Errorf("Err: want an expired token error, but actually[%v]", err)
+	}
+	if calls != 2 {
+		t.Errorf("Calls: want[%d], but actually[%d]", 2, calls)
+	}
+}
+
+func TestSendMsgContextCanceled(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := pb.SendMsgContext(ctx, pb.NewClient(ts.URL), postPath, nil, &pb.SendMsgTextPkg{ToUser: "tonybai"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Err: want[%v], but actually[%v]", context.DeadlineExceeded, err)
+	}
 }
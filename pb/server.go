@@ -0,0 +1,338 @@
+// Package pb provides the callback server for qy and mp.
+package pb
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBodyBytes is the default limit of the body of a callback request.
+const DefaultMaxBodyBytes = 1 << 20
+
+// Reply is the passive reply of a received message, such as
+// qy.RecvRespTextDataPkg. It is marshalled to xml as the response body.
+// []byte is seemed as marshalled xml and is sent as is. A nil Reply
+// answers "success", which means no reply.
+type Reply interface{}
+
+// MsgHandler handles a message package parsed by RecvHandler.
+type MsgHandler interface {
+	ServeMsg(ctx context.Context, pkg interface{}) Reply
+}
+
+// MsgHandlerFunc is an adapter to allow the use of ordinary functions
+// as MsgHandler.
+type MsgHandlerFunc func(ctx context.Context, pkg interface{}) Reply
+
+// ServeMsg calls f(ctx, pkg).
+func (f MsgHandlerFunc) ServeMsg(ctx context.Context, pkg interface{}) Reply {
+	return f(ctx, pkg)
+}
+
+// MarshalReply returns the xml data of reply.
+func MarshalReply(reply Reply) ([]byte, error) {
+	if data, ok := reply.([]byte); ok {
+		return data, nil
+	}
+	return xml.Marshal(reply)
+}
+
+// ResponseReply marshals reply and returns the response body of it made by
+// recv, which is encrypted if needed.
+func ResponseReply(recv RecvHandler, reply Reply, encryptType string) ([]byte, error) {
+	msg, err := MarshalReply(reply)
+	if err != nil {
+		return nil, err
+	}
+	return recv.Response(msg, encryptType)
+}
+
+// URLVerifier validates the url verification request, which is a GET request
+// with echostr in query, and returns the data to answer.
+type URLVerifier func(query url.Values) ([]byte, bool)
+
+// Server is an http.Handler serving the callback url of qy or mp. It answers
+// the url verification requests, and for a message request it parses the
+// message with RecvHandler, dispatches it to Handler, and responds with
+// the reply. mp.NewServer and qy.NewServer create it.
+type Server struct {
+	// Handler handles the received messages.
+	Handler MsgHandler
+
+	// MaxBodyBytes limits the body of a message request.
+	// DefaultMaxBodyBytes is used if it is zero.
+	MaxBodyBytes int64
+
+	// ErrorLog logs the requests failed to be parsed or responded. The
+	// standard logger is used if it is nil.
+	ErrorLog *log.Logger
+
+	// Dedupe recognises the retries of the messages handled or being
+	// handled, and answers them with the reply of the first delivery
+	// instead of dispatching them to Handler again. No dedupe is done if
+	// it is nil.
+	Dedupe DedupeStore
+
+	// Guard, if not nil, rejects the replayed requests. With Dedupe, the
+	// nonce of a message is checked only when it is dispatched, since wechat
+	// platform may retry a message with the same nonce; the retries are
+	// answered by Dedupe instead. mp.NewServer and qy.NewServer set it by
+	// pb.WithReplayGuard.
+	Guard *ReplayGuard
+
+	// DedupeTTL is the time a message is remembered by Dedupe.
+	// DefaultDedupeTTL is used if it is zero.
+	DedupeTTL time.Duration
+
+	// Deliver enables the async mode if it is not nil. In the async mode
+	// the handlers run in a bounded pool of workers, and the request is
+	// answered with no reply if the handler does not reply within its
+	// deadline. The reply is delivered by Deliver later.
+	Deliver DeliverFunc
+
+	// AsyncDeadline is the time a handler has to reply in the async mode,
+	// unless Handler implements MsgDeadliner. DefaultAsyncDeadline is used
+	// if it is zero.
+	AsyncDeadline time.Duration
+
+	// AsyncWorkers limits the number of the handlers running at the same
+	// time in the async mode. DefaultAsyncWorkers is used if it is zero.
+	AsyncWorkers int
+
+	workersOnce sync.Once
+	sem         chan struct{}
+
+	recv   RecvHandler
+	verify URLVerifier
+}
+
+// NewServer creates a Server. The query parameters signature and
+// msg_signature of a message request are passed to RecvHandler.Parse.
+func NewServer(recv RecvHandler, verify URLVerifier, handler MsgHandler) *Server {
+	return &Server{
+		Handler: handler,
+		recv:    recv,
+		verify:  verify,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	switch r.Method {
+	case "GET":
+		echoStr, ok := s.verify(query)
+		if !ok {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+		w.Write(echoStr)
+	case "POST":
+		s.serveMsg(w, r, query)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveMsg(w http.ResponseWriter, r *http.Request, query url.Values) {
+	maxBodyBytes := s.MaxBodyBytes
+	if maxBodyBytes == 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		s.logf("read callback body error: %v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	encryptType := query.Get("encrypt_type")
+	pkg, err := s.recv.Parse(body, query.Get("signature"), query.Get("msg_signature"),
+		query.Get("timestamp"), query.Get("nonce"), encryptType)
+	if err == nil && s.Guard != nil {
+		err = s.Guard.checkTimestamp(query.Get("timestamp"))
+	}
+	if err != nil {
+		s.logf("parse callback message error: %v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// fresh checks the nonce of the message to be dispatched.
+	fresh := func() error {
+		if s.Guard == nil {
+			return nil
+		}
+		return s.Guard.checkNonce(query.Get("timestamp"), query.Get("nonce"))
+	}
+
+	if s.Dedupe != nil {
+		if key := DedupeKey(pkg); key != "" {
+			s.serveOnce(r.Context(), w, pkg, key, encryptType, fresh)
+			return
+		}
+	}
+
+	if err := fresh(); err != nil {
+		s.writeReplayed(w, err)
+		return
+	}
+	reply, err := s.dispatch(r.Context(), pkg)
+	if err != nil {
+		s.writeBusy(w, err)
+		return
+	}
+	s.writeReply(w, reply, encryptType)
+}
+
+// serveOnce dispatches pkg to Handler if it is the first delivery of key
+// and fresh accepts it, otherwise it waits for the reply of the first
+// delivery and answers it.
+func (s *Server) serveOnce(ctx context.Context, w http.ResponseWriter, pkg interface{}, key, encryptType string,
+	fresh func() error) {
+	ttl := s.DedupeTTL
+	if ttl == 0 {
+		ttl = DefaultDedupeTTL
+	}
+
+	for {
+		added, err := s.Dedupe.Add(ctx, key, ttl)
+		if err == nil && added {
+			if err := fresh(); err != nil {
+				s.Dedupe.Delete(ctx, key)
+				s.writeReplayed(w, err)
+				return
+			}
+			reply, err := s.handleOnce(ctx, pkg, key, ttl)
+			if err != nil {
+				s.writeBusy(w, err)
+				return
+			}
+			s.writeReply(w, reply, encryptType)
+			return
+		}
+
+		var cached []byte
+		var ok bool
+		if err == nil {
+			cached, ok, err = s.Dedupe.Get(ctx, key)
+		}
+		if err != nil {
+			// Handle it anyway rather than lose it.
+			s.logf("dedupe message %s error: %v", key, err)
+			if err := fresh(); err != nil {
+				s.writeReplayed(w, err)
+				return
+			}
+			reply, err := s.dispatch(ctx, pkg)
+			if err != nil {
+				s.writeBusy(w, err)
+				return
+			}
+			s.writeReply(w, reply, encryptType)
+			return
+		}
+		if ok {
+			if len(cached) == 0 {
+				s.writeReply(w, nil, encryptType)
+			} else {
+				s.writeReply(w, cached, encryptType)
+			}
+			return
+		}
+
+		// The first delivery is still being handled.
+		timer := time.NewTimer(dedupePollInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// handleOnce dispatches pkg to Handler and stores its marshalled reply as
+// the one of key. If Handler panics, key is deleted so that the retries
+// could handle it again, and so does a failure to dispatch or to store.
+func (s *Server) handleOnce(ctx context.Context, pkg interface{}, key string, ttl time.Duration) (Reply, error) {
+	stored := false
+	defer func() {
+		if !stored {
+			s.Dedupe.Delete(context.WithoutCancel(ctx), key)
+		}
+	}()
+
+	reply, err := s.dispatch(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+	msg := []byte{}
+	if reply != nil {
+		var err error
+		if msg, err = MarshalReply(reply); err != nil {
+			s.logf("respond callback message error: %v", err)
+			msg = []byte{}
+		}
+	}
+
+	// Store the reply even if the request is gone, for the retries.
+	if err := s.Dedupe.Set(context.WithoutCancel(ctx), key, msg, ttl); err != nil {
+		s.logf("dedupe message %s error: %v", key, err)
+	} else {
+		stored = true
+	}
+
+	if len(msg) == 0 {
+		return nil, nil
+	}
+	return msg, nil
+}
+
+// writeBusy answers that the message could not be handled now, so that
+// wechat platform retries it later.
+func (s *Server) writeBusy(w http.ResponseWriter, err error) {
+	s.logf("handle callback message error: %v", err)
+	http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+}
+
+// writeReplayed rejects the replayed request.
+func (s *Server) writeReplayed(w http.ResponseWriter, err error) {
+	s.logf("parse callback message error: %v", err)
+	http.Error(w, "bad request", http.StatusBadRequest)
+}
+
+// writeReply writes reply as the response body, encrypted if needed.
+func (s *Server) writeReply(w http.ResponseWriter, reply Reply, encryptType string) {
+	if reply == nil {
+		w.Write([]byte("success"))
+		return
+	}
+
+	msg, err := ResponseReply(s.recv, reply, encryptType)
+	if err != nil {
+		s.logf("respond callback message error: %v", err)
+		// Answer "success" so that wechat platform does not retry.
+		w.Write([]byte("success"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write(msg)
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
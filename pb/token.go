@@ -0,0 +1,274 @@
+// Package pb provides the access token cache for qy and mp.
+package pb
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRefreshAhead is how long before expiry the token is refreshed.
+	DefaultRefreshAhead = 5 * time.Minute
+	// DefaultRefreshJitter is the upper bound of the random time added to
+	// DefaultRefreshAhead, so that many processes do not refresh at once.
+	DefaultRefreshJitter = time.Minute
+	// DefaultRetryInterval is how long to wait before retrying a failed
+	// fetching.
+	DefaultRetryInterval = 2 * time.Second
+
+	// tokenLockTTL bounds how long a holder owns the lock of a TokenStore,
+	// and tokenPollInterval is how often the others check the store while
+	// waiting for the holder.
+	tokenLockTTL      = 30 * time.Second
+	tokenPollInterval = 200 * time.Millisecond
+)
+
+// TokenFetcher fetches a new access token from wechat platform.
+// It returns the token and its lifetime in seconds, just like FetchAccessToken.
+type TokenFetcher func(ctx context.Context) (string, float64, error)
+
+// TokenManager caches the access token fetched by a TokenFetcher and
+// refreshes it before it expires. Concurrent refreshes are coalesced into
+// one fetching. It is safe for concurrent use.
+type TokenManager struct {
+	// RefreshAhead and RefreshJitter decide when the cached token is
+	// refreshed: a token is refreshed in background once it is within
+	// RefreshAhead plus a random jitter in [0, RefreshJitter) of its expiry.
+	RefreshAhead  time.Duration
+	RefreshJitter time.Duration
+
+	// RetryInterval is how long to wait before retrying a failed fetching:
+	// a background refresh while the cached token is still valid, or a
+	// fetching without a valid token, whose error is returned to all the
+	// callers until then. Zero means retrying at the next call.
+	RetryInterval time.Duration
+
+	// Store, if not nil, shares the token under Key with the other
+	// TokenManagers using the same store. Only the one holding the lock
+	// of Key fetches a new token, the others read it from Store.
+	Store TokenStore
+	Key   string
+
+	fetch TokenFetcher
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	refreshAt time.Time
+	call      *tokenCall
+
+	// fetchErr is the error of the last fetching without a valid token,
+	// which is returned instead of fetching again until retryAt.
+	fetchErr error
+	retryAt  time.Time
+}
+
+// tokenCall is an in-flight token fetching shared by all the waiters.
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// NewTokenManager creates a TokenManager which gets tokens from fetch.
+func NewTokenManager(fetch TokenFetcher) *TokenManager {
+	return &TokenManager{
+		RefreshAhead:  DefaultRefreshAhead,
+		RefreshJitter: DefaultRefreshJitter,
+		RetryInterval: DefaultRetryInterval,
+		fetch:         fetch,
+	}
+}
+
+// Token returns the cached access token. It fetches a new one if there is
+// no valid token, and starts a background refresh if the token is about to
+// expire. If fetching without a valid token fails, the error is returned
+// for a while before another fetching.
+func (m *TokenManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	now := time.Now()
+	if m.token != "" && now.Before(m.expiresAt) {
+		token := m.token
+		if !now.Before(m.refreshAt) {
+			m.startLocked(ctx)
+		}
+		m.mu.Unlock()
+		return token, nil
+	}
+	if err := m.fetchErrLocked(now); err != nil {
+		m.mu.Unlock()
+		return "", err
+	}
+	call := m.startLocked(ctx)
+	m.mu.Unlock()
+
+	return call.wait(ctx)
+}
+
+// Refresh discards the cached token if it is stale, the one rejected by
+// wechat platform, and fetches a new one. If the cached token is another
+// one, which has been refreshed for the other callers of the stale one, it
+// is returned as is. If a fetching is already in flight, Refresh waits for
+// its result instead.
+func (m *TokenManager) Refresh(ctx context.Context, stale string) (string, error) {
+	m.mu.Lock()
+	now := time.Now()
+	if m.call == nil && m.token != "" && m.token != stale && now.Before(m.expiresAt) {
+		token := m.token
+		m.mu.Unlock()
+		return token, nil
+	}
+	if err := m.fetchErrLocked(now); err != nil {
+		m.mu.Unlock()
+		return "", err
+	}
+	call := m.startLocked(ctx)
+	m.token = ""
+	m.mu.Unlock()
+
+	return call.wait(ctx)
+}
+
+// fetchErrLocked returns the error of the last failed fetching if it is
+// too soon to fetch again. m.mu must be held.
+func (m *TokenManager) fetchErrLocked(now time.Time) error {
+	if m.call == nil && m.fetchErr != nil && now.Before(m.retryAt) {
+		return m.fetchErr
+	}
+	return nil
+}
+
+// startLocked starts a token fetching unless one is in flight.
+// m.mu must be held.
+func (m *TokenManager) startLocked(ctx context.Context) *tokenCall {
+	if m.call != nil {
+		return m.call
+	}
+
+	call := &tokenCall{done: make(chan struct{})}
+	m.call = call
+
+	// The fetching is shared by all the waiters, so it should not be
+	// canceled along with the one who happens to start it.
+	go m.doFetch(context.WithoutCancel(ctx), call, m.token)
+	return call
+}
+
+// doFetch gets a token to replace old and finishes call with it.
+func (m *TokenManager) doFetch(ctx context.Context, call *tokenCall, old string) {
+	var token string
+	var expiresAt time.Time
+	var err error
+	if m.Store == nil {
+		token, expiresAt, err = m.fetchNew(ctx)
+	} else {
+		token, expiresAt, err = m.load(ctx, old)
+	}
+
+	m.mu.Lock()
+	now := time.Now()
+	if err == nil {
+		m.token = token
+		m.expiresAt = expiresAt
+		m.refreshAt = now.Add(m.refreshAfter(expiresAt.Sub(now)))
+		m.fetchErr = nil
+	} else if m.token != "" && now.Before(m.expiresAt) {
+		m.refreshAt = now.Add(m.RetryInterval)
+	} else {
+		m.fetchErr = err
+		m.retryAt = now.Add(m.RetryInterval)
+	}
+	m.call = nil
+	m.mu.Unlock()
+
+	call.token, call.err = token, err
+	close(call.done)
+}
+
+// fetchNew fetches a new token with m.fetch.
+func (m *TokenManager) fetchNew(ctx context.Context) (string, time.Time, error) {
+	token, expiresIn, err := m.fetch(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if token == "" {
+		return "", time.Time{}, errors.New("empty access token")
+	}
+	return token, time.Now().Add(time.Duration(expiresIn * float64(time.Second))), nil
+}
+
+// load gets a token other than old from m.Store. If there is none, the
+// holder of the lock of m.Key fetches a new one and stores it, while the
+// others wait for it.
+func (m *TokenManager) load(ctx context.Context, old string) (string, time.Time, error) {
+	for {
+		token, expiresAt, err := m.Store.Get(ctx, m.Key)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if token != "" && token != old {
+			return token, expiresAt, nil
+		}
+
+		unlock, err := m.Store.Lock(ctx, m.Key, tokenLockTTL)
+		if err == nil {
+			return m.fetchAndStore(ctx, old, unlock)
+		}
+		if err != ErrTokenLocked {
+			return "", time.Time{}, err
+		}
+
+		select {
+		case <-time.After(tokenPollInterval):
+		case <-ctx.Done():
+			return "", time.Time{}, ctx.Err()
+		}
+	}
+}
+
+func (m *TokenManager) fetchAndStore(ctx context.Context, old string, unlock func() error) (string, time.Time, error) {
+	defer unlock()
+
+	// Another holder may have stored a new token just before we got the lock.
+	token, expiresAt, err := m.Store.Get(ctx, m.Key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if token != "" && token != old {
+		return token, expiresAt, nil
+	}
+
+	token, expiresAt, err = m.fetchNew(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err = m.Store.Set(ctx, m.Key, token, time.Until(expiresAt)); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// refreshAfter returns how long later a token which expires after lifetime
+// should be refreshed.
+func (m *TokenManager) refreshAfter(lifetime time.Duration) time.Duration {
+	ahead := m.RefreshAhead
+	if m.RefreshJitter > 0 {
+		ahead += time.Duration(rand.Int63n(int64(m.RefreshJitter)))
+	}
+	if ahead >= lifetime {
+		return lifetime / 2
+	}
+	return lifetime - ahead
+}
+
+func (call *tokenCall) wait(ctx context.Context) (string, error) {
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,269 @@
+package pb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+func TestTokenManagerCache(t *testing.T) {
+	var fetched int32
+	m := pb.NewTokenManager(func(ctx context.Context) (string, float64, error) {
+		atomic.AddInt32(&fetched, 1)
+		return accessToken, 7200, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		token, err := m.Token(context.Background())
+		if err != nil {
+			t.Fatal("Token error:", err)
+		}
+		if token != accessToken {
+			t.Errorf("Token: want[%s], but actually[%s]", accessToken, token)
+		}
+	}
+
+	if n := atomic.LoadInt32(&fetched); n != 1 {
+		t.Errorf("Fetched: want[%d], but actually[%d]", 1, n)
+	}
+}
+
+func TestTokenManagerCoalesce(t *testing.T) {
+	var fetched int32
+	release := make(chan struct{})
+	m := pb.NewTokenManager(func(ctx context.Context) (string, float64, error) {
+		atomic.AddInt32(&fetched, 1)
+		<-release
+		return accessToken, 7200, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := m.Token(context.Background())
+			if err != nil || token != accessToken {
+				t.Errorf("Token: want[%s], but actually[%s], err[%v]", accessToken, token, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetched); n != 1 {
+		t.Errorf("Fetched: want[%d], but actually[%d]", 1, n)
+	}
+}
+
+func TestTokenManagerRefreshAhead(t *testing.T) {
+	var fetched int32
+	refreshed := make(chan struct{}, 1)
+	m := pb.NewTokenManager(func(ctx context.Context) (string, float64, error) {
+		if atomic.AddInt32(&fetched, 1) == 2 {
+			refreshed <- struct{}{}
+			return "accesstoken000002", 1, nil
+		}
+		return accessToken, 1, nil
+	})
+	m.RefreshAhead = 900 * time.Millisecond
+	m.RefreshJitter = 0
+
+	if _, err := m.Token(context.Background()); err != nil {
+		t.Fatal("Token error:", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	// The old token is still valid, so it is returned while refreshing.
+	token, err := m.Token(context.Background())
+	if err != nil {
+		t.Fatal("Token error:", err)
+	}
+	if token != accessToken {
+		t.Errorf("Token: want[%s], but actually[%s]", accessToken, token)
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("want a background refresh, but actually there is none")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	token, _ = m.Token(context.Background())
+	if token != "accesstoken000002" {
+		t.Errorf("Token: want[%s], but actually[%s]", "accesstoken000002", token)
+	}
+}
+
+func TestTokenManagerRefreshStale(t *testing.T) {
+	var fetched int32
+	m := pb.NewTokenManager(func(ctx context.Context) (string, float64, error) {
+		n := atomic.AddInt32(&fetched, 1)
+		return fmt.Sprintf("accesstoken%06d", n), 7200, nil
+	})
+
+	stale, err := m.Token(context.Background())
+	if err != nil {
+		t.Fatal("Token error:", err)
+	}
+
+	// The calls with stale fail one after another, and only the first one
+	// refreshes it.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := m.Refresh(context.Background(), stale)
+			if err != nil || token != "accesstoken000002" {
+				t.Errorf("Refresh: want[%s], but actually[%s], err[%v]", "accesstoken000002", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetched); n != 2 {
+		t.Errorf("Fetched: want[%d], but actually[%d]", 2, n)
+	}
+}
+
+func TestTokenManagerError(t *testing.T) {
+	m := pb.NewTokenManager(func(ctx context.Context) (string, float64, error) {
+		return "", 0, errors.New("invalid corpid")
+	})
+
+	_, err := m.Token(context.Background())
+	if err == nil || err.Error() != "invalid corpid" {
+		t.Errorf("Err: want[%s], but actually[%v]", "invalid corpid", err)
+	}
+}
+
+func TestTokenManagerErrorRetryInterval(t *testing.T) {
+	var fetched int32
+	m := pb.NewTokenManager(func(ctx context.Context) (string, float64, error) {
+		atomic.AddInt32(&fetched, 1)
+		return "", 0, errors.New("invalid corpid")
+	})
+
+	for i := 0; i < 5; i++ {
+		_, err := m.Token(context.Background())
+		if err == nil || err.Error() != "invalid corpid" {
+			t.Errorf("Err: want[%s], but actually[%v]", "invalid corpid", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetched); n != 1 {
+		t.Errorf("Fetched: want[%d], but actually[%d]", 1, n)
+	}
+}
+
+func TestTokenManagerErrorRecover(t *testing.T) {
+	var fetched int32
+	m := pb.NewTokenManager(func(ctx context.Context) (string, float64, error) {
+		if atomic.AddInt32(&fetched, 1) == 1 {
+			return "", 0, errors.New("connection reset by peer")
+		}
+		return accessToken, 7200, nil
+	})
+	m.RetryInterval = 20 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		if _, err := m.Token(context.Background()); err == nil {
+			t.Error("Err: want an error within the retry interval, but actually nil")
+		}
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	token, err := m.Token(context.Background())
+	if err != nil || token != accessToken {
+		t.Errorf("Token: want[%s], but actually[%s], err[%v]", accessToken, token, err)
+	}
+	if n := atomic.LoadInt32(&fetched); n != 2 {
+		t.Errorf("Fetched: want[%d], but actually[%d]", 2, n)
+	}
+}
+
+func TestTokenManagerCanceled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	m := pb.NewTokenManager(func(ctx context.Context) (string, float64, error) {
+		<-release
+		return accessToken, 7200, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.Token(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Err: want[%v], but actually[%v]", context.DeadlineExceeded, err)
+	}
+}
+
+func TestTokenManagerStoreShared(t *testing.T) {
+	var fetched int32
+	fetch := func(ctx context.Context) (string, float64, error) {
+		atomic.AddInt32(&fetched, 1)
+		time.Sleep(20 * time.Millisecond)
+		return accessToken, 7200, nil
+	}
+
+	store := pb.NewMemoryTokenStore()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		// Every TokenManager stands for one replica.
+		m := pb.NewTokenManager(fetch)
+		m.Store = store
+		m.Key = "mp:" + corpID
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := m.Token(context.Background())
+			if err != nil || token != accessToken {
+				t.Errorf("Token: want[%s], but actually[%s], err[%v]", accessToken, token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetched); n != 1 {
+		t.Errorf("Fetched: want[%d], but actually[%d]", 1, n)
+	}
+}
+
+func TestTokenManagerStoreRefresh(t *testing.T) {
+	var fetched int32
+	store := pb.NewMemoryTokenStore()
+	store.Set(context.Background(), "mp:"+corpID, accessToken, time.Hour)
+
+	m := pb.NewTokenManager(func(ctx context.Context) (string, float64, error) {
+		atomic.AddInt32(&fetched, 1)
+		return "accesstoken000002", 7200, nil
+	})
+	m.Store = store
+	m.Key = "mp:" + corpID
+
+	token, _ := m.Token(context.Background())
+	if token != accessToken {
+		t.Errorf("Token: want[%s], but actually[%s]", accessToken, token)
+	}
+
+	// The stored token is revoked, so a new one is fetched and stored.
+	token, _ = m.Refresh(context.Background(), token)
+	if token != "accesstoken000002" {
+		t.Errorf("Token: want[%s], but actually[%s]", "accesstoken000002", token)
+	}
+	stored, _, _ := store.Get(context.Background(), "mp:"+corpID)
+	if stored != "accesstoken000002" {
+		t.Errorf("Stored: want[%s], but actually[%s]", "accesstoken000002", stored)
+	}
+	if n := atomic.LoadInt32(&fetched); n != 1 {
+		t.Errorf("Fetched: want[%d], but actually[%d]", 1, n)
+	}
+}
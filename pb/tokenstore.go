@@ -0,0 +1,292 @@
+// Package pb provides the access token stores shared by many processes.
+package pb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTokenLocked is returned by TokenStore.Lock if the lock is held by
+// another holder.
+var ErrTokenLocked = errors.New("access token is locked by another holder")
+
+// TokenStore stores the access tokens shared by many TokenManagers, such as
+// the ones in the replicas of a service. Only the holder of the lock of a key
+// refreshes the token, the others read the token it stores.
+type TokenStore interface {
+	// Get returns the token stored under key and the time it expires.
+	// It returns an empty token if there is none.
+	Get(ctx context.Context, key string) (string, time.Time, error)
+
+	// Set stores token under key for ttl.
+	Set(ctx context.Context, key, token string, ttl time.Duration) error
+
+	// Lock acquires the lock of key for at most ttl. It returns
+	// ErrTokenLocked if the lock is held by another holder, otherwise the
+	// returned unlock releases the lock.
+	Lock(ctx context.Context, key string, ttl time.Duration) (unlock func() error, err error)
+}
+
+// MemoryTokenStore is a TokenStore shared by the TokenManagers in one process.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]storedToken
+	locks  map[string]time.Time
+}
+
+type storedToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewMemoryTokenStore creates an empty MemoryTokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: make(map[string]storedToken),
+		locks:  make(map[string]time.Time),
+	}
+}
+
+// Get implements TokenStore.
+func (s *MemoryTokenStore) Get(ctx context.Context, key string) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[key]
+	if !ok || !time.Now().Before(t.ExpiresAt) {
+		return "", time.Time{}, nil
+	}
+	return t.Token, t.ExpiresAt, nil
+}
+
+// Set implements TokenStore.
+func (s *MemoryTokenStore) Set(ctx context.Context, key, token string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[key] = storedToken{Token: token, ExpiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// Lock implements TokenStore.
+func (s *MemoryTokenStore) Lock(ctx context.Context, key string, ttl time.Duration) (func() error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if expiresAt, ok := s.locks[key]; ok && now.Before(expiresAt) {
+		return nil, ErrTokenLocked
+	}
+	expiresAt := now.Add(ttl)
+	s.locks[key] = expiresAt
+
+	return func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// The lock may have expired and been acquired by another holder.
+		if s.locks[key].Equal(expiresAt) {
+			delete(s.locks, key)
+		}
+		return nil
+	}, nil
+}
+
+// FileTokenStore is a TokenStore shared by the processes on one host, or on
+// many hosts through a shared file system. The token of key is stored in
+// file "key.token" of the directory, and its lock is file "key.lock".
+type FileTokenStore struct {
+	dir string
+}
+
+// NewFileTokenStore creates a FileTokenStore in dir, creating dir if needed.
+func NewFileTokenStore(dir string) (*FileTokenStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileTokenStore{dir: dir}, nil
+}
+
+// path returns the file of key with suffix ext. Characters of key which are
+// not safe in file names are replaced with '_'.
+func (s *FileTokenStore) path(key, ext string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, key)
+	return filepath.Join(s.dir, name+ext)
+}
+
+// Get implements TokenStore.
+func (s *FileTokenStore) Get(ctx context.Context, key string) (string, time.Time, error) {
+	data, err := ioutil.ReadFile(s.path(key, ".token"))
+	if os.IsNotExist(err) {
+		return "", time.Time{}, nil
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	t := storedToken{}
+	if err = json.Unmarshal(data, &t); err != nil {
+		return "", time.Time{}, err
+	}
+	if !time.Now().Before(t.ExpiresAt) {
+		return "", time.Time{}, nil
+	}
+	return t.Token, t.ExpiresAt, nil
+}
+
+// Set implements TokenStore. The token file is replaced atomically, so the
+// readers never see a partial one.
+func (s *FileTokenStore) Set(ctx context.Context, key, token string, ttl time.Duration) error {
+	data, err := json.Marshal(storedToken{Token: token, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(s.dir, ".token-")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path(key, ".token"))
+}
+
+// Lock implements TokenStore. The lock file records its owner and expiry,
+// so a lock left by a crashed holder is taken over after ttl.
+func (s *FileTokenStore) Lock(ctx context.Context, key string, ttl time.Duration) (func() error, error) {
+	lockPath := s.path(key, ".lock")
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	owner := fileLock{Owner: hex.EncodeToString(id), ExpiresAt: time.Now().Add(ttl)}
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.create(lockPath, data)
+	if os.IsExist(err) {
+		if !lockFileExpired(lockPath, ttl) {
+			return nil, ErrTokenLocked
+		}
+		err = s.takeOver(key, lockPath, data, ttl)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		// Only remove the lock file we own.
+		held, err := readFileLock(lockPath)
+		if err != nil || held.Owner != owner.Owner {
+			return nil
+		}
+		return os.Remove(lockPath)
+	}, nil
+}
+
+// takeOver replaces the expired lock file in lockPath with data. The takers
+// are serialized by the takeover file of key, so that the lock file is only
+// removed by the one who still finds it expired while holding the takeover
+// file, and the others get ErrTokenLocked. A takeover file left by a crashed
+// taker is removed after ttl.
+func (s *FileTokenStore) takeOver(key, lockPath string, data []byte, ttl time.Duration) error {
+	takeoverPath := s.path(key, ".takeover")
+	err := s.create(takeoverPath, nil)
+	if os.IsExist(err) {
+		if fi, err := os.Stat(takeoverPath); err == nil && time.Since(fi.ModTime()) >= ttl {
+			os.Remove(takeoverPath)
+		}
+		return ErrTokenLocked
+	}
+	if err != nil {
+		return err
+	}
+	defer os.Remove(takeoverPath)
+
+	// Another taker may have taken it over just before.
+	if !lockFileExpired(lockPath, ttl) {
+		return ErrTokenLocked
+	}
+	if err = os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = s.create(lockPath, data)
+	if os.IsExist(err) {
+		return ErrTokenLocked
+	}
+	return err
+}
+
+// create creates the file in path with data atomically, so the readers
+// never see a partial one. It fails with an error satisfying os.IsExist if
+// path exists.
+func (s *FileTokenStore) create(path string, data []byte) error {
+	f, err := ioutil.TempFile(s.dir, ".lock-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Link(f.Name(), path)
+}
+
+// fileLock is the content of a lock file.
+type fileLock struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// lockFileExpired reports whether the lock file in path has expired. A lock
+// file which could not be read may be being written by its owner, it is
+// seemed as expired only if it is older than ttl.
+func lockFileExpired(path string, ttl time.Duration) bool {
+	held, err := readFileLock(path)
+	if err == nil {
+		return !time.Now().Before(held.ExpiresAt)
+	}
+	fi, err := os.Stat(path)
+	return err == nil && time.Since(fi.ModTime()) >= ttl
+}
+
+func readFileLock(path string) (*fileLock, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &fileLock{}
+	if err = json.Unmarshal(data, l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
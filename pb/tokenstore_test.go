@@ -0,0 +1,107 @@
+package pb_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+func testTokenStore(t *testing.T, store pb.TokenStore) {
+	ctx := context.Background()
+	key := "qy:" + corpID
+
+	token, _, err := store.Get(ctx, key)
+	if err != nil || token != "" {
+		t.Errorf("Get: want empty token, but actually[%s], err[%v]", token, err)
+	}
+
+	if err = store.Set(ctx, key, accessToken, time.Hour); err != nil {
+		t.Fatal("Set error:", err)
+	}
+	token, expiresAt, err := store.Get(ctx, key)
+	if err != nil || token != accessToken {
+		t.Errorf("Get: want[%s], but actually[%s], err[%v]", accessToken, token, err)
+	}
+	if d := time.Until(expiresAt); d <= 59*time.Minute || d > time.Hour {
+		t.Errorf("ExpiresAt: want about one hour later, but actually[%s] later", d)
+	}
+
+	unlock, err := store.Lock(ctx, key, time.Minute)
+	if err != nil {
+		t.Fatal("Lock error:", err)
+	}
+	if _, err = store.Lock(ctx, key, time.Minute); err != pb.ErrTokenLocked {
+		t.Errorf("Lock: want[%v], but actually[%v]", pb.ErrTokenLocked, err)
+	}
+	if err = unlock(); err != nil {
+		t.Fatal("Unlock error:", err)
+	}
+	unlock, err = store.Lock(ctx, key, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal("Lock error:", err)
+	}
+
+	// An expired lock is taken over.
+	time.Sleep(20 * time.Millisecond)
+	if _, err = store.Lock(ctx, key, time.Minute); err != nil {
+		t.Errorf("Lock: want nil, but actually[%v]", err)
+	}
+	unlock()
+}
+
+// testTokenStoreTakeover takes over an expired lock concurrently, and only
+// one of the takers should hold the lock.
+func testTokenStoreTakeover(t *testing.T, store pb.TokenStore) {
+	ctx := context.Background()
+	key := "qy:" + corpID
+
+	if _, err := store.Lock(ctx, key, 10*time.Millisecond); err != nil {
+		t.Fatal("Lock error:", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	var holders int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := store.Lock(ctx, key, time.Minute)
+			if err == nil {
+				atomic.AddInt32(&holders, 1)
+			} else if err != pb.ErrTokenLocked {
+				t.Errorf("Lock: want nil or [%v], but actually[%v]", pb.ErrTokenLocked, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&holders); n != 1 {
+		t.Errorf("Holders: want[%d], but actually[%d]", 1, n)
+	}
+}
+
+func TestMemoryTokenStore(t *testing.T) {
+	testTokenStore(t, pb.NewMemoryTokenStore())
+	testTokenStoreTakeover(t, pb.NewMemoryTokenStore())
+}
+
+func TestFileTokenStore(t *testing.T) {
+	store, err := pb.NewFileTokenStore(t.TempDir())
+	if err != nil {
+		t.Fatal("NewFileTokenStore error:", err)
+	}
+	testTokenStore(t, store)
+
+	if store, err = pb.NewFileTokenStore(t.TempDir()); err != nil {
+		t.Fatal("NewFileTokenStore error:", err)
+	}
+	testTokenStoreTakeover(t, store)
+}
@@ -2,22 +2,32 @@
 package qy
 
 import (
-	"strings"
+	"context"
+	"net/url"
 
 	"github.com/bigwhite/gowechat/pb"
 )
 
-const (
-	accessTokenFetchURL = "https://qyapi.weixin.qq.com/cgi-bin/gettoken"
-)
-
 // FetchAccessToken could be used to fetch access token for wechat qy dev.
 func FetchAccessToken(corpID, corpSecret string) (string, float64, error) {
-	requestLine := strings.Join([]string{accessTokenFetchURL,
-		"?corpid=",
-		corpID,
-		"&corpsecret=",
-		corpSecret}, "")
+	return FetchAccessTokenContext(context.Background(), corpID, corpSecret)
+}
+
+// FetchAccessTokenContext is like FetchAccessToken with a context.
+func FetchAccessTokenContext(ctx context.Context, corpID, corpSecret string) (string, float64, error) {
+	return NewClient(corpID, corpSecret).FetchAccessTokenContext(ctx)
+}
+
+// FetchAccessToken fetches the access token of c.CorpID.
+func (c *Client) FetchAccessToken() (string, float64, error) {
+	return c.FetchAccessTokenContext(context.Background())
+}
+
+// FetchAccessTokenContext is like FetchAccessToken with a context.
+func (c *Client) FetchAccessTokenContext(ctx context.Context) (string, float64, error) {
+	query := url.Values{}
+	query.Set("corpid", c.CorpID)
+	query.Set("corpsecret", c.CorpSecret)
 
-	return pb.FetchAccessToken(requestLine)
+	return pb.FetchAccessTokenContext(ctx, c.Client, accessTokenFetchPath, query)
 }
@@ -0,0 +1,57 @@
+// Package qy provides the api client for wechat qy dev.
+package qy
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+const (
+	// DefaultBaseURL is the location of wechat qy api.
+	DefaultBaseURL = "https://qyapi.weixin.qq.com"
+
+	accessTokenFetchPath = "/cgi-bin/gettoken"
+	menuCreatePath       = "/cgi-bin/menu/create"
+	sendPath             = "/cgi-bin/message/send"
+)
+
+// Client is used to call wechat qy api on behalf of one corp app.
+// The embedded pb.Client could be adjusted to change the base url,
+// the http client and the timeout. Its TokenSource is Tokens, and its
+// RetryErrcodes are the frequency and concurrency limits of qy, which are
+// retried as RateLimitRetries says.
+//
+// A Client should be created by NewClient and shared by all the callers,
+// so that the access token cached in Tokens is shared too.
+type Client struct {
+	*pb.Client
+	CorpID     string
+	CorpSecret string
+	Tokens     *pb.TokenManager
+}
+
+// NewClient creates a Client for corpID which talks to DefaultBaseURL.
+func NewClient(corpID, corpSecret string) *Client {
+	c := &Client{
+		Client:     pb.NewClient(DefaultBaseURL),
+		CorpID:     corpID,
+		CorpSecret: corpSecret,
+	}
+	c.Tokens = pb.NewTokenManager(func(ctx context.Context) (string, float64, error) {
+		return c.FetchAccessTokenContext(ctx)
+	})
+	c.TokenSource = c.Tokens
+	// The frequency limit of qy is 45009, which is the daily quota of mp.
+	c.RetryErrcodes = []int{pb.ErrcodeDailyQuotaExceeded, pb.ErrcodeConcurrencyExceeded}
+	// Every app of a corp has its own secret and access token.
+	c.Tokens.Key = fmt.Sprintf("qy:%s:%x", corpID, sha1.Sum([]byte(corpSecret)))
+	return c
+}
+
+// Token returns the cached access token of c, fetching a new one if needed.
+func (c *Client) Token(ctx context.Context) (string, error) {
+	return c.Tokens.Token(ctx)
+}
@@ -0,0 +1,46 @@
+package qy_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bigwhite/gowechat/pb"
+	"github.com/bigwhite/gowechat/qy"
+)
+
+func TestClientSendMsgRateLimited(t *testing.T) {
+	for _, errcode := range []int{pb.ErrcodeDailyQuotaExceeded, pb.ErrcodeConcurrencyExceeded} {
+		calls := 0
+		mux := http.NewServeMux()
+		mux.HandleFunc("/cgi-bin/gettoken", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"access_token": "` + accessToken + `", "expires_in": 7200}`))
+		})
+		mux.HandleFunc("/cgi-bin/message/send", func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls < 3 {
+				fmt.Fprintf(w, `{"errcode": %d, "errmsg": "api freq out of limit"}`, errcode)
+				return
+			}
+			w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+		})
+		ts := httptest.NewServer(mux)
+
+		c := qy.NewClient(serverCorpID, "secret")
+		c.BaseURL = ts.URL
+		c.RateLimitRetries = 2
+		c.RateLimitBackoff = time.Millisecond
+
+		pkg := &qy.SendMsgTextPkg{AgentID: "3"}
+		pkg.ToUser = "@all"
+		pkg.MsgType = "text"
+		err := c.SendMsgContext(context.Background(), pkg)
+		ts.Close()
+		if err != nil || calls != 3 {
+			t.Errorf("SendMsg of %d: want[3 <nil>], but actually[%d %v]", errcode, calls, err)
+		}
+	}
+}
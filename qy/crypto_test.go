@@ -54,7 +54,7 @@ func TestDecryptMsg1(t *testing.T) {
 	}
 
 	if recvMsg.Content != "hello body" {
-		t.Errorf("Msg: want[%s], but actually[%s]", "hello body", recvMsg)
+		t.Errorf("Msg: want[%s], but actually[%s]", "hello body", recvMsg.Content)
 	}
 }
 
@@ -91,7 +91,7 @@ func TestEcryptMsg(t *testing.T) {
 	}
 
 	if recvMsg.Content != "hello body" {
-		t.Errorf("Msg: want[%s], but actually[%s]", "hello body", recvMsg)
+		t.Errorf("Msg: want[%s], but actually[%s]", "hello body", recvMsg.Content)
 	}
 
 	if msgLen != len(msgText) {
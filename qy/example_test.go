@@ -2,6 +2,8 @@ package qy_test
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 
 	"github.com/bigwhite/gowechat/qy"
 )
@@ -45,9 +47,24 @@ const (
 	agentID = "5"
 )
 
-func ExampleCreateMenu() {
-	err := qy.CreateMenu([]byte(layout), accessToken, agentID)
+func ExampleClient_CreateMenu() {
+	// A stand-in server for wechat qy api.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cgi-bin/gettoken", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token": "` + accessToken + `", "expires_in": 7200}`))
+	})
+	mux.HandleFunc("/cgi-bin/menu/create", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println(r.URL.Path + "?" + r.URL.RawQuery)
+		w.Write([]byte(`{"errcode": 40056, "errmsg": "invalid agentid"}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := qy.NewClient("wxfd4448417439fd3x", "secret")
+	c.BaseURL = ts.URL
+
+	err := c.CreateMenu([]byte(layout), agentID)
 	fmt.Println(err)
-	// Output: https://qyapi.weixin.qq.com/cgi-bin/menu/create?access_token=wx1234abcd&agentid=5
-	// invalid access_token
+	// Output: /cgi-bin/menu/create?access_token=wx1234abcd&agentid=5
+	// wechat api /cgi-bin/menu/create: errcode 40056: invalid agentid
 }
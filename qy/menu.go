@@ -2,19 +2,33 @@
 package qy
 
 import (
-	"fmt"
-	"strings"
+	"context"
+	"net/url"
 
 	"github.com/bigwhite/gowechat/pb"
 )
 
-const (
-	url = "https://qyapi.weixin.qq.com/cgi-bin/menu/create"
-)
-
 func CreateMenu(menuLayout []byte, accessToken, agentID string) error {
-	reqLine := strings.Join([]string{url, "?access_token=", accessToken, "&agentid=", agentID}, "")
-	fmt.Println(reqLine)
-	return pb.CreateMenu(reqLine, menuLayout)
+	return CreateMenuContext(context.Background(), menuLayout, accessToken, agentID)
+}
+
+// CreateMenuContext is like CreateMenu with a context.
+func CreateMenuContext(ctx context.Context, menuLayout []byte, accessToken, agentID string) error {
+	query := url.Values{}
+	query.Set("access_token", accessToken)
+	query.Set("agentid", agentID)
+	return pb.CreateMenuContext(ctx, pb.NewClient(DefaultBaseURL), menuCreatePath, query, menuLayout)
+}
+
+// CreateMenu creates the custom menu described by menuLayout for app agentID
+// with the access token of c.
+func (c *Client) CreateMenu(menuLayout []byte, agentID string) error {
+	return c.CreateMenuContext(context.Background(), menuLayout, agentID)
+}
 
+// CreateMenuContext is like CreateMenu with a context.
+func (c *Client) CreateMenuContext(ctx context.Context, menuLayout []byte, agentID string) error {
+	query := url.Values{}
+	query.Set("agentid", agentID)
+	return pb.CreateMenuContext(ctx, c.Client, menuCreatePath, query, menuLayout)
 }
@@ -3,7 +3,6 @@ package qy
 
 import (
 	"encoding/xml"
-	"errors"
 	"fmt"
 
 	"github.com/bigwhite/gowechat/pb"
@@ -31,6 +30,33 @@ const (
 	PicWeiXinEvent       = "pic_weixin"
 	LocationSelectEvent  = "location_select"
 	EnterAgentEvent      = "enter_agent"
+	ChangeContactEvent   = "change_contact"
+	BatchJobResultEvent  = "batch_job_result"
+	ApprovalChangeEvent  = "sys_approval_change"
+
+	// Change type of change_contact event
+	CreateUserChange  = "create_user"
+	UpdateUserChange  = "update_user"
+	DeleteUserChange  = "delete_user"
+	CreatePartyChange = "create_party"
+	UpdatePartyChange = "update_party"
+	DeletePartyChange = "delete_party"
+	UpdateTagChange   = "update_tag"
+
+	// Job type of batch_job_result event
+	SyncUserJob     = "sync_user"
+	ReplaceUserJob  = "replace_user"
+	InviteUserJob   = "invite_user"
+	ReplacePartyJob = "replace_party"
+
+	// SpStatus of sys_approval_change event
+	ApprovalPending         = 1
+	ApprovalApproved        = 2
+	ApprovalRejected        = 3
+	ApprovalRevoked         = 4
+	ApprovalRevokedApproved = 6
+	ApprovalDeleted         = 7
+	ApprovalPaid            = 10
 )
 
 // RecvTextDataPkg is a Text Message received from wechat platform.
@@ -111,52 +137,260 @@ type RecvEnterAgentDataPkg struct {
 	pb.RecvBaseDataPkg
 	Event    string
 	EventKey string
+	AgentID  int
+}
+
+// ScanCodeInfo is the scan result of a scancode event.
+type ScanCodeInfo = pb.ScanCodeInfo
+
+// RecvScanCodeEventDataPkg is a scancode_push or scancode_waitmsg event
+// Message received from wechat platform.
+type RecvScanCodeEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event        string
+	EventKey     string
+	ScanCodeInfo ScanCodeInfo
+	AgentID      int
+}
+
+// PicItem is a picture sent by a pic event.
+type PicItem = pb.PicItem
+
+// SendPicsInfo is the pictures sent by a pic event.
+type SendPicsInfo = pb.SendPicsInfo
+
+// RecvPicEventDataPkg is a pic_sysphoto, pic_photo_or_album or pic_weixin
+// event Message received from wechat platform.
+type RecvPicEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event        string
+	EventKey     string
+	SendPicsInfo SendPicsInfo
+	AgentID      int
+}
+
+// SendLocationInfo is the location sent by a location_select event.
+type SendLocationInfo = pb.SendLocationInfo
+
+// RecvLocationSelectEventDataPkg is a location_select event Message
+// received from wechat platform.
+type RecvLocationSelectEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event            string
+	EventKey         string
+	SendLocationInfo SendLocationInfo
+	AgentID          int
+}
+
+// ExtAttrText is the value of a text extended attribute.
+type ExtAttrText struct {
+	Value string
+}
+
+// ExtAttrWeb is the value of a web extended attribute.
+type ExtAttrWeb struct {
+	Title string
+	URL   string `xml:"Url"`
+}
+
+// ExtAttrItem is an extended attribute of a user. Value is set by the
+// early version of the callback, and Text or Web, by Type, is set by the
+// later one.
+type ExtAttrItem struct {
+	Name  string
+	Value string
+	Type  int
+	Text  ExtAttrText
+	Web   ExtAttrWeb
+}
+
+// RecvUserChangeEventDataPkg is a change_contact event Message of the
+// create_user, update_user or delete_user change type received from wechat
+// platform. Only UserID is set for delete_user, and the changed fields are
+// set for update_user. Department and IsLeaderInDept are comma separated.
+type RecvUserChangeEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event          string
+	ChangeType     string
+	UserID         string
+	NewUserID      string
+	Name           string
+	Department     string
+	MainDepartment int
+	IsLeaderInDept string
+	Position       string
+	Mobile         string
+	Gender         int
+	Email          string
+	Status         int
+	Avatar         string
+	Alias          string
+	Telephone      string
+	Address        string
+	ExtAttr        []ExtAttrItem `xml:"ExtAttr>Item"`
+}
+
+// DedupeKey implements pb.DedupeKeyer with pb.PayloadKey.
+func (pkg *RecvUserChangeEventDataPkg) DedupeKey() string {
+	return pb.PayloadKey(pkg)
+}
+
+// RecvPartyChangeEventDataPkg is a change_contact event Message of the
+// create_party, update_party or delete_party change type received from
+// wechat platform. Only ID is set for delete_party, and the changed fields
+// are set for update_party.
+type RecvPartyChangeEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event      string
+	ChangeType string
+	ID         int `xml:"Id"`
+	Name       string
+	ParentID   int `xml:"ParentId"`
+	Order      int
+}
+
+// DedupeKey implements pb.DedupeKeyer with pb.PayloadKey.
+func (pkg *RecvPartyChangeEventDataPkg) DedupeKey() string {
+	return pb.PayloadKey(pkg)
+}
+
+// RecvTagChangeEventDataPkg is a change_contact event Message of the
+// update_tag change type received from wechat platform. The items are
+// comma separated.
+type RecvTagChangeEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event         string
+	ChangeType    string
+	TagID         int `xml:"TagId"`
+	AddUserItems  string
+	DelUserItems  string
+	AddPartyItems string
+	DelPartyItems string
+}
+
+// DedupeKey implements pb.DedupeKeyer with pb.PayloadKey.
+func (pkg *RecvTagChangeEventDataPkg) DedupeKey() string {
+	return pb.PayloadKey(pkg)
+}
+
+// BatchJob is the result of an async batch job.
+type BatchJob struct {
+	JobID   string `xml:"JobId"`
+	JobType string
+	ErrCode int
+	ErrMsg  string
 }
 
+// RecvBatchJobResultEventDataPkg is a batch_job_result event Message
+// received from wechat platform when an async batch job finishes.
+type RecvBatchJobResultEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event    string
+	BatchJob BatchJob
+}
+
+// DedupeKey implements pb.DedupeKeyer with pb.PayloadKey.
+func (pkg *RecvBatchJobResultEventDataPkg) DedupeKey() string {
+	return pb.PayloadKey(pkg)
+}
+
+// ApprovalUser is a user in an approval.
+type ApprovalUser struct {
+	UserID string `xml:"UserId"`
+}
+
+// ApprovalApplyer is the applicant of an approval.
+type ApprovalApplyer struct {
+	UserID string `xml:"UserId"`
+	Party  string
+}
+
+// ApprovalDetail is the decision of an approver on an approval node.
+type ApprovalDetail struct {
+	Approver ApprovalUser
+	Speech   string
+	SpStatus int
+	SpTime   int64
+	MediaID  []string `xml:"MediaId"`
+}
+
+// ApprovalRecord is an approval node. ApproverAttr is 1 if any of the
+// approvers decides the node, and 2 if all of them must approve.
+type ApprovalRecord struct {
+	SpStatus     int
+	ApproverAttr int
+	Details      []ApprovalDetail
+}
+
+// ApprovalComment is a comment on an approval.
+type ApprovalComment struct {
+	CommentUserInfo ApprovalUser
+	CommentTime     int64
+	CommentContent  string
+	CommentID       string   `xml:"CommentId"`
+	MediaID         []string `xml:"MediaId"`
+}
+
+// ApprovalInfo is the approval changed. SpStatus is one of the ApprovalXxx
+// constants, and StatuChangeEvent tells the change, such as 1 for applying
+// and 2 for approving.
+type ApprovalInfo struct {
+	SpNo             string
+	SpName           string
+	SpStatus         int
+	TemplateID       string `xml:"TemplateId"`
+	ApplyTime        int64
+	Applyer          ApprovalApplyer
+	SpRecord         []ApprovalRecord
+	Notifyer         []ApprovalUser
+	Comments         []ApprovalComment
+	StatuChangeEvent int
+}
+
+// RecvApprovalChangeEventDataPkg is a sys_approval_change event Message
+// received from wechat platform when an approval changes.
+type RecvApprovalChangeEventDataPkg struct {
+	pb.RecvBaseDataPkg
+	Event        string
+	AgentID      int
+	ApprovalInfo ApprovalInfo
+}
+
+// DedupeKey implements pb.DedupeKeyer with pb.PayloadKey.
+func (pkg *RecvApprovalChangeEventDataPkg) DedupeKey() string {
+	return pb.PayloadKey(pkg)
+}
+
+// RecvRespTextDataPkg is a Text reply to the received message.
 type RecvRespTextDataPkg struct {
 	pb.RecvRespBaseDataPkg
 	Content pb.CDATAText
 }
 
 type recvHandler struct {
-	corpID         string
-	token          string
-	encodingAESKey string
-}
-
-// RecvHTTPReqBody is a unmarshall result for below xml data:
-// <xml>
-// 	<ToUserName><![CDATA[toUser]]</ToUserName>
-// 	<AgentID><![CDATA[toAgentID]]</AgentID>
-// 	<Encrypt><![CDATA[msg_encrypt]]</Encrypt>
-// </xml>
-type RecvHTTPReqBody struct {
-	ToUserName string
-	AgentID    string
-	Encrypt    string
-}
-
-// RecvHTTPResqBody is a source for marshalling below xml data:
-// <xml>
-// 	<Encrypt><![CDATA[msg_encrypt]]></Encrypt>
-// 	<MsgSignature><![CDATA[msg_signature]]></MsgSignature>
-// 	<TimeStamp>timestamp</TimeStamp>
-// 	<Nonce><![CDATA[nonce]]></Nonce>
-// </xml>
-type RecvHTTPRespBody struct {
-	XMLName      xml.Name `xml:"xml"`
-	Encrypt      pb.CDATAText
-	MsgSignature pb.CDATAText
-	TimeStamp    int
-	Nonce        pb.CDATAText
+	guard *pb.ReplayGuard
+
+	// env is nil with envErr if encodingAESKey is invalid, which is
+	// reported when a message is parsed or responded.
+	env    *pb.Envelope
+	envErr error
 }
 
+// RecvHTTPReqBody is the encrypted request body of wechat qy.
+type RecvHTTPReqBody = pb.EncryptReqBody
+
+// RecvHTTPRespBody is the encrypted response body to wechat qy.
+type RecvHTTPRespBody = pb.EncryptRespBody
+
 // NewRecvHandler creates an instance of recvHandler
 // which implements pb.RecvHandler interface.
-func NewRecvHandler(corpID, token, encodingAESKey string) pb.RecvHandler {
-	return &recvHandler{corpID: corpID,
-		token:          token,
-		encodingAESKey: encodingAESKey}
+// pb.WithReplayGuard in opts rejects the replayed requests.
+func NewRecvHandler(corpID, token, encodingAESKey string, opts ...pb.RecvOption) pb.RecvHandler {
+	o := pb.NewRecvOptions(opts...)
+	env, err := pb.NewEnvelope(corpID, token, encodingAESKey)
+	return &recvHandler{guard: o.Guard.WithScope(corpID),
+		env:    env,
+		envErr: err}
 }
 
 // Parse used to parse the receive "post" data request.
@@ -164,36 +398,41 @@ func NewRecvHandler(corpID, token, encodingAESKey string) pb.RecvHandler {
 //
 // Note: We suppose that r.ParseForm() has been invoked before entering this method.
 // and we suppose that you have validate the URL in the post request.
-func (h *recvHandler) Parse(bodyText []byte, signature, timestamp, nonce, encryptType string /* not used */) (interface{}, error) {
-	var err error
+//
+// The requests of qy are signed by msgSignature only, so signature is not
+// used, nor is encryptType.
+func (h *recvHandler) Parse(bodyText []byte, signature, msgSignature, timestamp, nonce, encryptType string) (interface{}, error) {
+	if h.envErr != nil {
+		return nil, h.envErr
+	}
 
 	// XML decoding.
-	reqBody := &RecvHTTPReqBody{}
-	if err = xml.Unmarshal(bodyText, reqBody); err != nil {
+	reqBody, err := h.env.ParseBody(bodyText)
+	if err != nil {
 		return nil, err
 	}
 
 	// Validate signature.
-	if !ValidateSignature(signature, h.token, timestamp, nonce, reqBody.Encrypt) {
-		return nil, errors.New("validate signature error")
+	if !h.env.Verify(msgSignature, timestamp, nonce, reqBody.Encrypt) {
+		return nil, pb.ErrInvalidSignature
+	}
+	if h.guard != nil {
+		if err = h.guard.Check(timestamp, nonce); err != nil {
+			return nil, err
+		}
 	}
 
 	// Decrpyt the "Encrypt" field.
-	var origData []byte
-	var corpID string
-	origData, _, corpID, err = DecryptMsg(reqBody.Encrypt, h.encodingAESKey)
+	origData, err := h.env.Decrypt(reqBody.Encrypt)
 	if err != nil {
 		return nil, err
 	}
 
-	if corpID != h.corpID {
-		return nil, fmt.Errorf("the request is from corp[%s], not from corp[%s]", corpID, h.corpID)
-	}
-
 	// Probe the type of message.
 	probePkg := &struct {
-		MsgType string
-		Event   string
+		MsgType    string
+		Event      string
+		ChangeType string
 	}{}
 	if err = xml.Unmarshal(origData, probePkg); err != nil {
 		return nil, err
@@ -221,12 +460,27 @@ func (h *recvHandler) Parse(bodyText []byte, signature, timestamp, nonce, encryp
 			dataPkg = &RecvMenuEventDataPkg{}
 		case EnterAgentEvent:
 			dataPkg = &RecvEnterAgentDataPkg{}
-		case ScanCodePushEvent:
-		case ScanCodeWaitEvent:
-		case PicSysPhotoEvent:
-		case PicPhotoOrAlbumEvent:
-		case PicWeiXinEvent:
+		case ScanCodePushEvent, ScanCodeWaitEvent:
+			dataPkg = &RecvScanCodeEventDataPkg{}
+		case PicSysPhotoEvent, PicPhotoOrAlbumEvent, PicWeiXinEvent:
+			dataPkg = &RecvPicEventDataPkg{}
 		case LocationSelectEvent:
+			dataPkg = &RecvLocationSelectEventDataPkg{}
+		case BatchJobResultEvent:
+			dataPkg = &RecvBatchJobResultEventDataPkg{}
+		case ApprovalChangeEvent:
+			dataPkg = &RecvApprovalChangeEventDataPkg{}
+		case ChangeContactEvent:
+			switch probePkg.ChangeType {
+			case CreateUserChange, UpdateUserChange, DeleteUserChange:
+				dataPkg = &RecvUserChangeEventDataPkg{}
+			case CreatePartyChange, UpdatePartyChange, DeletePartyChange:
+				dataPkg = &RecvPartyChangeEventDataPkg{}
+			case UpdateTagChange:
+				dataPkg = &RecvTagChangeEventDataPkg{}
+			default:
+				return nil, fmt.Errorf("unknown change type: %s", probePkg.ChangeType)
+			}
 		default:
 			return nil, fmt.Errorf("unknown event type: %s", probePkg.Event)
 		}
@@ -243,29 +497,16 @@ func (h *recvHandler) Parse(bodyText []byte, signature, timestamp, nonce, encryp
 
 // Response returns the response body data for the request from wechat qy platform.
 func (h *recvHandler) Response(msg []byte, encryptType string /* not used */) ([]byte, error) {
-	msgEncrypt, err := EncryptMsg(msg, h.corpID, h.encodingAESKey)
-	if err != nil {
-		return nil, err
+	if h.envErr != nil {
+		return nil, h.envErr
 	}
-
-	nonce := pb.GenNonce()
-	timestamp := pb.GenTimestamp()
-
-	signature := genSignature(h.token, fmt.Sprintf("%d", timestamp), nonce, msgEncrypt)
-	resp := &RecvHTTPRespBody{
-		Encrypt:      pb.String2CDATA(msgEncrypt),
-		MsgSignature: pb.String2CDATA(signature),
-		TimeStamp:    timestamp,
-		Nonce:        pb.String2CDATA(nonce),
-	}
-
-	return xml.MarshalIndent(resp, " ", "  ")
+	return h.env.Seal(msg)
 }
 
 // ValidateSignature is used to validate the signature in request to figure out
 // whether the http request come from wechat qy platform.
 func ValidateSignature(signature, token, timestamp, nonce, msgEncrypt string) bool {
-	return signature == genSignature(token, timestamp, nonce, msgEncrypt)
+	return pb.SignatureEqual(signature, genSignature(token, timestamp, nonce, msgEncrypt))
 }
 
 // dev_msg_signature=sha1(sort(token、timestamp、nonce、msg_encrypt))
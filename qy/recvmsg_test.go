@@ -1,8 +1,11 @@
 package qy_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
+	"github.com/bigwhite/gowechat/pb"
 	"github.com/bigwhite/gowechat/qy"
 )
 
@@ -67,3 +70,353 @@ func TestValidateURLOk(t *testing.T) {
 		t.Errorf("want [%s], but actually the echoStr is [%s]", echoStrWanted, string(echoStr))
 	}
 }
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte(textMsg), false)
+	f.Add([]byte("0123456789abcdef\xff\xff\xff\xff"), false)
+	f.Add([]byte("0123456789abcdef\x00\x00\x00\x03<a>"+serverCorpID), false)
+	f.Add([]byte("sq1d1sgR6C39QKNRJk21zIwWZrVY4EJrpX3cVJznqSqeNJjbzbjUOMnrFAHGREBi"), true)
+
+	h := qy.NewRecvHandler(serverCorpID, serverToken, encodingAESKey)
+	f.Fuzz(func(t *testing.T, data []byte, raw bool) {
+		timestamp, nonce := "1426498001", "1019369511"
+
+		msgEncrypt := string(data)
+		if !raw {
+			// data is the decrypted payload, which is well encrypted.
+			var err error
+			if msgEncrypt, err = pb.EncryptMsg(data, encodingAESKey); err != nil {
+				t.Fatal("EncryptMsg error:", err)
+			}
+		}
+		body := "<xml><Encrypt><![CDATA[" + msgEncrypt + "]]></Encrypt></xml>"
+		signature := pb.GenSignature(serverToken, timestamp, nonce, msgEncrypt)
+		h.Parse([]byte(body), "", signature, timestamp, nonce, "")
+	})
+}
+
+func TestParseMalformed(t *testing.T) {
+	h := qy.NewRecvHandler(serverCorpID, serverToken, encodingAESKey)
+	timestamp, nonce := "1426498001", "1019369511"
+
+	tests := []struct {
+		origData []byte
+		want     error
+	}{
+		{[]byte("0123456789abcdef\x00\x00"), pb.ErrBadLength},
+		{[]byte("0123456789abcdef\x7f\xff\xff\xffhello"), pb.ErrBadLength},
+		{append([]byte("0123456789abcdef\x00\x00\x00\x05hello"), "wx5823bf96d3bd56c7"...), pb.ErrAppIDMismatch},
+	}
+	for _, tt := range tests {
+		msgEncrypt, err := pb.EncryptMsg(tt.origData, encodingAESKey)
+		if err != nil {
+			t.Fatal("EncryptMsg error:", err)
+		}
+		body := "<xml><Encrypt><![CDATA[" + msgEncrypt + "]]></Encrypt></xml>"
+		signature := pb.GenSignature(serverToken, timestamp, nonce, msgEncrypt)
+		if _, err := h.Parse([]byte(body), "", signature, timestamp, nonce, ""); !errors.Is(err, tt.want) {
+			t.Errorf("Parse %q: want[%v], but actually[%v]", tt.origData, tt.want, err)
+		}
+	}
+}
+
+// parseMsg parses msg encrypted and signed like wechat qy platform.
+func parseMsg(t *testing.T, msg string) interface{} {
+	h := qy.NewRecvHandler(serverCorpID, serverToken, encodingAESKey)
+	timestamp, nonce := "1426498001", "1019369511"
+
+	msgEncrypt, err := qy.EncryptMsg([]byte(msg), serverCorpID, encodingAESKey)
+	if err != nil {
+		t.Fatal("EncryptMsg error:", err)
+	}
+	body := "<xml><Encrypt><![CDATA[" + msgEncrypt + "]]></Encrypt></xml>"
+	signature := pb.GenSignature(serverToken, timestamp, nonce, msgEncrypt)
+	pkg, err := h.Parse([]byte(body), "", signature, timestamp, nonce, "")
+	if err != nil {
+		t.Fatalf("Parse [%s] error: %s", msg, err)
+	}
+	return pkg
+}
+
+func TestParseScanCodeEvent(t *testing.T) {
+	msg := `<xml><ToUserName><![CDATA[wx2f6d0a549c129f06]]></ToUserName>
+<FromUserName><![CDATA[baim]]></FromUserName>
+<CreateTime>1408090502</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[scancode_waitmsg]]></Event>
+<EventKey><![CDATA[6]]></EventKey>
+<ScanCodeInfo><ScanType><![CDATA[qrcode]]></ScanType>
+<ScanResult><![CDATA[2]]></ScanResult>
+</ScanCodeInfo>
+<AgentID>1</AgentID>
+</xml>`
+
+	pkg, ok := parseMsg(t, msg).(*qy.RecvScanCodeEventDataPkg)
+	if !ok {
+		t.Fatalf("want *qy.RecvScanCodeEventDataPkg, but actually it is not")
+	}
+	if pkg.Event != qy.ScanCodeWaitEvent || pkg.EventKey != "6" || pkg.AgentID != 1 {
+		t.Errorf("Event: want[%s 6 1], but actually[%s %s %d]", qy.ScanCodeWaitEvent, pkg.Event, pkg.EventKey, pkg.AgentID)
+	}
+	if pkg.ScanCodeInfo.ScanType != "qrcode" || pkg.ScanCodeInfo.ScanResult != "2" {
+		t.Errorf("ScanCodeInfo: want[qrcode 2], but actually[%s %s]", pkg.ScanCodeInfo.ScanType, pkg.ScanCodeInfo.ScanResult)
+	}
+}
+
+func TestParsePicEvent(t *testing.T) {
+	msg := `<xml><ToUserName><![CDATA[wx2f6d0a549c129f06]]></ToUserName>
+<FromUserName><![CDATA[baim]]></FromUserName>
+<CreateTime>1408090816</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[pic_photo_or_album]]></Event>
+<EventKey><![CDATA[6]]></EventKey>
+<SendPicsInfo><Count>2</Count>
+<PicList><item><PicMd5Sum><![CDATA[5a75aaca956d97be686719218f275c6b]]></PicMd5Sum>
+</item>
+<item><PicMd5Sum><![CDATA[1b5f7c23b5bf75682a53e7b6d163e185]]></PicMd5Sum>
+</item>
+</PicList>
+</SendPicsInfo>
+<AgentID>1</AgentID>
+</xml>`
+
+	pkg, ok := parseMsg(t, msg).(*qy.RecvPicEventDataPkg)
+	if !ok {
+		t.Fatalf("want *qy.RecvPicEventDataPkg, but actually it is not")
+	}
+	if pkg.Event != qy.PicPhotoOrAlbumEvent || pkg.AgentID != 1 {
+		t.Errorf("Event: want[%s 1], but actually[%s %d]", qy.PicPhotoOrAlbumEvent, pkg.Event, pkg.AgentID)
+	}
+	info := pkg.SendPicsInfo
+	if info.Count != 2 || len(info.PicList) != 2 {
+		t.Fatalf("SendPicsInfo: want[2 pictures], but actually[%d %d]", info.Count, len(info.PicList))
+	}
+	if info.PicList[1].PicMd5Sum != "1b5f7c23b5bf75682a53e7b6d163e185" {
+		t.Errorf("PicMd5Sum: want[1b5f7c23b5bf75682a53e7b6d163e185], but actually[%s]", info.PicList[1].PicMd5Sum)
+	}
+}
+
+func TestParseLocationSelectEvent(t *testing.T) {
+	msg := `<xml><ToUserName><![CDATA[wx2f6d0a549c129f06]]></ToUserName>
+<FromUserName><![CDATA[baim]]></FromUserName>
+<CreateTime>1408091189</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[location_select]]></Event>
+<EventKey><![CDATA[6]]></EventKey>
+<SendLocationInfo><Location_X><![CDATA[23]]></Location_X>
+<Location_Y><![CDATA[113]]></Location_Y>
+<Scale><![CDATA[15]]></Scale>
+<Label><![CDATA[ 广州市海珠区客村艺苑路 106号]]></Label>
+<Poiname><![CDATA[]]></Poiname>
+</SendLocationInfo>
+<AgentID>1</AgentID>
+</xml>`
+
+	pkg, ok := parseMsg(t, msg).(*qy.RecvLocationSelectEventDataPkg)
+	if !ok {
+		t.Fatalf("want *qy.RecvLocationSelectEventDataPkg, but actually it is not")
+	}
+	if pkg.Event != qy.LocationSelectEvent || pkg.AgentID != 1 {
+		t.Errorf("Event: want[%s 1], but actually[%s %d]", qy.LocationSelectEvent, pkg.Event, pkg.AgentID)
+	}
+	info := pkg.SendLocationInfo
+	if info.LocX != 23 || info.LocY != 113 || info.Scale != 15 {
+		t.Errorf("SendLocationInfo: want[23 113 15], but actually[%v %v %d]", info.LocX, info.LocY, info.Scale)
+	}
+	if info.Label != " 广州市海珠区客村艺苑路 106号" {
+		t.Errorf("Label: want[ 广州市海珠区客村艺苑路 106号], but actually[%s]", info.Label)
+	}
+}
+
+func TestParseChangeContactEvent(t *testing.T) {
+	userMsg := `<xml><ToUserName><![CDATA[wx2f6d0a549c129f06]]></ToUserName>
+<FromUserName><![CDATA[sys]]></FromUserName>
+<CreateTime>1403610513</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[change_contact]]></Event>
+<ChangeType>update_user</ChangeType>
+<UserID><![CDATA[zhangsan]]></UserID>
+<NewUserID><![CDATA[zhangsan001]]></NewUserID>
+<Name><![CDATA[张三]]></Name>
+<Department><![CDATA[1,2,3]]></Department>
+<MainDepartment>1</MainDepartment>
+<IsLeaderInDept><![CDATA[1,0,0]]></IsLeaderInDept>
+<Position><![CDATA[产品经理]]></Position>
+<Mobile>13800000000</Mobile>
+<Gender>1</Gender>
+<Email><![CDATA[zhangsan@gzdev.com]]></Email>
+<Status>1</Status>
+<Avatar><![CDATA[http://wx.qlogo.cn/mmopen/ajNVdqHZLLA3WJ6DSZUfiakYe37PKnQhBIeOQBO4czqrnZDS79FH5Wm5m4X69TBicnHFlhiafvDwklOpZeXYQQ2icg/0]]></Avatar>
+<Alias><![CDATA[zhangsan]]></Alias>
+<Telephone><![CDATA[020-123456]]></Telephone>
+<Address><![CDATA[广州市]]></Address>
+<ExtAttr>
+<Item><Name><![CDATA[爱好]]></Name><Type>0</Type><Text><Value><![CDATA[旅游]]></Value></Text></Item>
+<Item><Name><![CDATA[卡号]]></Name><Type>1</Type><Web><Title><![CDATA[企业微信]]></Title><Url><![CDATA[https://work.weixin.qq.com]]></Url></Web></Item>
+</ExtAttr>
+</xml>`
+
+	user, ok := parseMsg(t, userMsg).(*qy.RecvUserChangeEventDataPkg)
+	if !ok {
+		t.Fatalf("want *qy.RecvUserChangeEventDataPkg, but actually it is not")
+	}
+	if user.ChangeType != qy.UpdateUserChange || user.UserID != "zhangsan" || user.NewUserID != "zhangsan001" {
+		t.Errorf("User: want[%s zhangsan zhangsan001], but actually[%s %s %s]",
+			qy.UpdateUserChange, user.ChangeType, user.UserID, user.NewUserID)
+	}
+	if user.Department != "1,2,3" || user.MainDepartment != 1 || user.IsLeaderInDept != "1,0,0" {
+		t.Errorf("Department: want[1,2,3 1 1,0,0], but actually[%s %d %s]", user.Department, user.MainDepartment, user.IsLeaderInDept)
+	}
+	if len(user.ExtAttr) != 2 {
+		t.Fatalf("ExtAttr: want[2 items], but actually[%d]", len(user.ExtAttr))
+	}
+	if user.ExtAttr[0].Text.Value != "旅游" || user.ExtAttr[1].Web.URL != "https://work.weixin.qq.com" {
+		t.Errorf("ExtAttr: want[旅游 https://work.weixin.qq.com], but actually[%s %s]",
+			user.ExtAttr[0].Text.Value, user.ExtAttr[1].Web.URL)
+	}
+
+	partyMsg := `<xml><ToUserName><![CDATA[wx2f6d0a549c129f06]]></ToUserName>
+<FromUserName><![CDATA[sys]]></FromUserName>
+<CreateTime>1403610513</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[change_contact]]></Event>
+<ChangeType>create_party</ChangeType>
+<Id>2</Id>
+<Name><![CDATA[张三]]></Name>
+<ParentId><![CDATA[1]]></ParentId>
+<Order>1</Order>
+</xml>`
+
+	party, ok := parseMsg(t, partyMsg).(*qy.RecvPartyChangeEventDataPkg)
+	if !ok {
+		t.Fatalf("want *qy.RecvPartyChangeEventDataPkg, but actually it is not")
+	}
+	if party.ChangeType != qy.CreatePartyChange || party.ID != 2 || party.ParentID != 1 || party.Order != 1 {
+		t.Errorf("Party: want[%s 2 1 1], but actually[%s %d %d %d]",
+			qy.CreatePartyChange, party.ChangeType, party.ID, party.ParentID, party.Order)
+	}
+
+	tagMsg := `<xml><ToUserName><![CDATA[wx2f6d0a549c129f06]]></ToUserName>
+<FromUserName><![CDATA[sys]]></FromUserName>
+<CreateTime>1403610513</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[change_contact]]></Event>
+<ChangeType><![CDATA[update_tag]]></ChangeType>
+<TagId>1</TagId>
+<AddUserItems><![CDATA[zhangsan,lisi]]></AddUserItems>
+<DelUserItems><![CDATA[zhangsan1,lisi1]]></DelUserItems>
+<AddPartyItems><![CDATA[1,2]]></AddPartyItems>
+<DelPartyItems><![CDATA[3,4]]></DelPartyItems>
+</xml>`
+
+	tag, ok := parseMsg(t, tagMsg).(*qy.RecvTagChangeEventDataPkg)
+	if !ok {
+		t.Fatalf("want *qy.RecvTagChangeEventDataPkg, but actually it is not")
+	}
+	if tag.TagID != 1 || tag.AddUserItems != "zhangsan,lisi" || tag.DelPartyItems != "3,4" {
+		t.Errorf("Tag: want[1 zhangsan,lisi 3,4], but actually[%d %s %s]", tag.TagID, tag.AddUserItems, tag.DelPartyItems)
+	}
+
+	// The events of a batch share FromUserName and CreateTime.
+	if pb.DedupeKey(user) == pb.DedupeKey(party) || pb.DedupeKey(party) == pb.DedupeKey(tag) {
+		t.Errorf("DedupeKey: want distinct keys, but actually[%s %s %s]", pb.DedupeKey(user), pb.DedupeKey(party), pb.DedupeKey(tag))
+	}
+
+	// So do the changes of the same tag in a second.
+	tag2 := parseMsg(t, strings.Replace(tagMsg, "zhangsan,lisi", "wangwu", 1))
+	if pb.DedupeKey(tag) == pb.DedupeKey(tag2) {
+		t.Errorf("DedupeKey: want distinct keys, but actually[%s]", pb.DedupeKey(tag))
+	}
+	if again := parseMsg(t, tagMsg); pb.DedupeKey(again) != pb.DedupeKey(tag) {
+		t.Errorf("DedupeKey of the retry: want[%s], but actually[%s]", pb.DedupeKey(tag), pb.DedupeKey(again))
+	}
+}
+
+func TestParseBatchJobResultEvent(t *testing.T) {
+	msg := `<xml><ToUserName><![CDATA[wx2f6d0a549c129f06]]></ToUserName>
+<FromUserName><![CDATA[sys]]></FromUserName>
+<CreateTime>1425284517</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[batch_job_result]]></Event>
+<BatchJob><JobId><![CDATA[S0MrnndvRG5fadSlLwiBqiDDbM143UqTmKP3152FZk4]]></JobId>
+<JobType><![CDATA[sync_user]]></JobType>
+<ErrCode>0</ErrCode>
+<ErrMsg><![CDATA[ok]]></ErrMsg>
+</BatchJob>
+</xml>`
+
+	pkg, ok := parseMsg(t, msg).(*qy.RecvBatchJobResultEventDataPkg)
+	if !ok {
+		t.Fatalf("want *qy.RecvBatchJobResultEventDataPkg, but actually it is not")
+	}
+	job := pkg.BatchJob
+	if job.JobID != "S0MrnndvRG5fadSlLwiBqiDDbM143UqTmKP3152FZk4" || job.JobType != qy.SyncUserJob || job.ErrCode != 0 || job.ErrMsg != "ok" {
+		t.Errorf("BatchJob: want[S0MrnndvRG5fadSlLwiBqiDDbM143UqTmKP3152FZk4 %s 0 ok], but actually[%s %s %d %s]",
+			qy.SyncUserJob, job.JobID, job.JobType, job.ErrCode, job.ErrMsg)
+	}
+}
+
+func TestParseApprovalChangeEvent(t *testing.T) {
+	msg := `<xml><ToUserName><![CDATA[wx2f6d0a549c129f06]]></ToUserName>
+<FromUserName><![CDATA[sys]]></FromUserName>
+<CreateTime>1571732272</CreateTime>
+<MsgType><![CDATA[event]]></MsgType>
+<Event><![CDATA[sys_approval_change]]></Event>
+<AgentID>3010040</AgentID>
+<ApprovalInfo>
+<SpNo>201910220003</SpNo>
+<SpName><![CDATA[示例模板]]></SpName>
+<SpStatus>1</SpStatus>
+<TemplateId><![CDATA[3TmALk1ogfgKiQE3e3jRwnTUhMTh8vca1N8zUVNU]]></TemplateId>
+<ApplyTime>1571732272</ApplyTime>
+<Applyer><UserId><![CDATA[WuJunJie]]></UserId><Party><![CDATA[1]]></Party></Applyer>
+<SpRecord><SpStatus>2</SpStatus><ApproverAttr>1</ApproverAttr>
+<Details><Approver><UserId><![CDATA[WangXiaoMing]]></UserId></Approver><Speech><![CDATA[同意]]></Speech><SpStatus>2</SpStatus><SpTime>1571732300</SpTime></Details>
+</SpRecord>
+<SpRecord><SpStatus>1</SpStatus><ApproverAttr>2</ApproverAttr>
+<Details><Approver><UserId><![CDATA[LiuXiaoGang]]></UserId></Approver><Speech><![CDATA[]]></Speech><SpStatus>1</SpStatus><SpTime>0</SpTime></Details>
+<Details><Approver><UserId><![CDATA[ChenXiaoYi]]></UserId></Approver><Speech><![CDATA[]]></Speech><SpStatus>1</SpStatus><SpTime>0</SpTime></Details>
+</SpRecord>
+<Notifyer><UserId><![CDATA[LiuXiaoGang]]></UserId></Notifyer>
+<Comments><CommentUserInfo><UserId><![CDATA[LiuXiaoGang]]></UserId></CommentUserInfo><CommentTime>1571732301</CommentTime><CommentContent><![CDATA[好]]></CommentContent><CommentId><![CDATA[6750538708562308000]]></CommentId></Comments>
+<StatuChangeEvent>2</StatuChangeEvent>
+</ApprovalInfo>
+</xml>`
+
+	pkg, ok := parseMsg(t, msg).(*qy.RecvApprovalChangeEventDataPkg)
+	if !ok {
+		t.Fatalf("want *qy.RecvApprovalChangeEventDataPkg, but actually it is not")
+	}
+	info := pkg.ApprovalInfo
+	if pkg.AgentID != 3010040 || info.SpNo != "201910220003" || info.SpStatus != qy.ApprovalPending || info.StatuChangeEvent != 2 {
+		t.Errorf("ApprovalInfo: want[3010040 201910220003 %d 2], but actually[%d %s %d %d]",
+			qy.ApprovalPending, pkg.AgentID, info.SpNo, info.SpStatus, info.StatuChangeEvent)
+	}
+	if info.Applyer.UserID != "WuJunJie" || info.Applyer.Party != "1" {
+		t.Errorf("Applyer: want[WuJunJie 1], but actually[%s %s]", info.Applyer.UserID, info.Applyer.Party)
+	}
+	if len(info.SpRecord) != 2 || len(info.SpRecord[1].Details) != 2 {
+		t.Fatalf("SpRecord: want[2 nodes, 2 approvers in the second], but actually[%d]", len(info.SpRecord))
+	}
+	if d := info.SpRecord[0].Details[0]; d.Approver.UserID != "WangXiaoMing" || d.SpStatus != qy.ApprovalApproved || d.Speech != "同意" {
+		t.Errorf("Details: want[WangXiaoMing %d 同意], but actually[%s %d %s]", qy.ApprovalApproved, d.Approver.UserID, d.SpStatus, d.Speech)
+	}
+	if info.SpRecord[1].ApproverAttr != 2 || info.SpRecord[1].Details[1].Approver.UserID != "ChenXiaoYi" {
+		t.Errorf("SpRecord: want[2 ChenXiaoYi], but actually[%d %s]", info.SpRecord[1].ApproverAttr, info.SpRecord[1].Details[1].Approver.UserID)
+	}
+	if len(info.Notifyer) != 1 || len(info.Comments) != 1 || info.Comments[0].CommentContent != "好" {
+		t.Errorf("Notifyer and Comments: want[1 1 好], but actually[%d %d]", len(info.Notifyer), len(info.Comments))
+	}
+
+	// The countersigners approving in the same second get the events of the
+	// same approval and change.
+	approve := func(user string) string {
+		pending := user + "]]></UserId></Approver><Speech><![CDATA[]]></Speech><SpStatus>1</SpStatus><SpTime>0</SpTime>"
+		approved := user + "]]></UserId></Approver><Speech><![CDATA[]]></Speech><SpStatus>2</SpStatus><SpTime>1571732272</SpTime>"
+		return strings.Replace(msg, pending, approved, 1)
+	}
+	liu, chen := parseMsg(t, approve("LiuXiaoGang")), parseMsg(t, approve("ChenXiaoYi"))
+	if pb.DedupeKey(liu) == pb.DedupeKey(chen) {
+		t.Errorf("DedupeKey: want distinct keys, but actually[%s]", pb.DedupeKey(liu))
+	}
+}
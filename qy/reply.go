@@ -0,0 +1,205 @@
+// Package qy provides the passive reply messages.
+package qy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+const (
+	// Reply msg type, besides text, image, voice and video.
+	NewsMsg         = "news"
+	UpdateButtonMsg = "update_button"
+)
+
+// RespMedia is the media of an image or voice reply.
+type RespMedia = pb.RespMedia
+
+// RecvRespImageDataPkg is a Image reply to the received message.
+type RecvRespImageDataPkg struct {
+	pb.RecvRespBaseDataPkg
+	Image RespMedia
+}
+
+// RecvRespVoiceDataPkg is a Voice reply to the received message.
+type RecvRespVoiceDataPkg struct {
+	pb.RecvRespBaseDataPkg
+	Voice RespMedia
+}
+
+// RespVideo is the video of a video reply.
+type RespVideo = pb.RespVideo
+
+// RecvRespVideoDataPkg is a Video reply to the received message.
+type RecvRespVideoDataPkg struct {
+	pb.RecvRespBaseDataPkg
+	Video RespVideo
+}
+
+// RespArticle is an article in a news reply.
+type RespArticle = pb.RespArticle
+
+// RecvRespNewsDataPkg is a News reply to the received message.
+type RecvRespNewsDataPkg = pb.RecvRespNewsDataPkg
+
+// RespButton is the button of an update_button reply.
+type RespButton struct {
+	ReplaceName pb.CDATAText
+}
+
+// RecvRespUpdateButtonDataPkg is a reply to the click of a button of a
+// template card, which replaces the text of the clicked button.
+type RecvRespUpdateButtonDataPkg struct {
+	pb.RecvRespBaseDataPkg
+	Button RespButton
+}
+
+// NewRecvRespTextDataPkg creates a Text reply to recv.
+func NewRecvRespTextDataPkg(recv pb.RecvPkg, content string) *RecvRespTextDataPkg {
+	return &RecvRespTextDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, TextMsg),
+		Content:             pb.String2CDATA(content),
+	}
+}
+
+// NewRecvRespImageDataPkg creates a Image reply to recv with the image
+// uploaded as mediaID.
+func NewRecvRespImageDataPkg(recv pb.RecvPkg, mediaID string) *RecvRespImageDataPkg {
+	return &RecvRespImageDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, ImageMsg),
+		Image:               RespMedia{MediaID: pb.String2CDATA(mediaID)},
+	}
+}
+
+// NewRecvRespVoiceDataPkg creates a Voice reply to recv with the voice
+// uploaded as mediaID.
+func NewRecvRespVoiceDataPkg(recv pb.RecvPkg, mediaID string) *RecvRespVoiceDataPkg {
+	return &RecvRespVoiceDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, VoiceMsg),
+		Voice:               RespMedia{MediaID: pb.String2CDATA(mediaID)},
+	}
+}
+
+// NewRecvRespVideoDataPkg creates a Video reply to recv with the video
+// uploaded as mediaID.
+func NewRecvRespVideoDataPkg(recv pb.RecvPkg, mediaID, title, description string) *RecvRespVideoDataPkg {
+	return &RecvRespVideoDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, VideoMsg),
+		Video: RespVideo{
+			MediaID:     pb.String2CDATA(mediaID),
+			Title:       pb.String2CDATA(title),
+			Description: pb.String2CDATA(description),
+		},
+	}
+}
+
+// NewRecvRespNewsDataPkg creates a News reply to recv with articles, which
+// are the same as the ones of SendMsgNewsPkg.
+func NewRecvRespNewsDataPkg(recv pb.RecvPkg, articles ...Article) *RecvRespNewsDataPkg {
+	pkg := &RecvRespNewsDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, NewsMsg),
+		ArticleCount:        len(articles),
+		Articles:            make([]RespArticle, 0, len(articles)),
+	}
+	for _, a := range articles {
+		pkg.Articles = append(pkg.Articles, RespArticle{
+			Title:       pb.String2CDATA(a.Title),
+			Description: pb.String2CDATA(a.Description),
+			PicURL:      pb.String2CDATA(a.PicUrl),
+			URL:         pb.String2CDATA(a.Url),
+		})
+	}
+	return pkg
+}
+
+// NewRecvRespUpdateButtonDataPkg creates a reply to the button click event
+// recv, which replaces the text of the clicked button with replaceName.
+func NewRecvRespUpdateButtonDataPkg(recv pb.RecvPkg, replaceName string) *RecvRespUpdateButtonDataPkg {
+	return &RecvRespUpdateButtonDataPkg{
+		RecvRespBaseDataPkg: pb.NewRecvRespBaseDataPkg(recv, UpdateButtonMsg),
+		Button:              RespButton{ReplaceName: pb.String2CDATA(replaceName)},
+	}
+}
+
+// DeliverReply sends reply to the sender of the received message pkg by
+// the agent which pkg is sent to. It is a pb.DeliverFunc for the async mode
+// of the callback server. The replies created by NewRecvRespXxxDataPkg,
+// except update_button, are converted to the messages of SendMsg, and the
+// others are sent as is.
+func (c *Client) DeliverReply(ctx context.Context, pkg interface{}, reply pb.Reply) error {
+	msg, err := agentMsg(pkg, reply)
+	if err != nil {
+		return err
+	}
+	return c.SendMsgContext(ctx, msg)
+}
+
+// agentMsg converts reply to the message sent by the agent of pkg.
+func agentMsg(pkg interface{}, reply pb.Reply) (interface{}, error) {
+	if _, ok := reply.([]byte); ok {
+		return nil, errors.New("the marshalled reply could not be delivered")
+	}
+	if _, ok := reply.(*RecvRespUpdateButtonDataPkg); ok {
+		return nil, errors.New("the update_button reply could not be delivered")
+	}
+
+	var agentID string
+	if v := reflect.ValueOf(pkg); v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.Struct {
+		if f := v.Elem().FieldByName("AgentID"); f.IsValid() && f.Kind() == reflect.Int {
+			agentID = strconv.FormatInt(f.Int(), 10)
+		}
+	}
+
+	switch r := reply.(type) {
+	case *RecvRespTextDataPkg:
+		msg := &SendMsgTextPkg{AgentID: agentID}
+		msg.ToUser = pb.CDATA2String(r.ToUserName)
+		msg.MsgType = TextMsg
+		msg.Text.Content = pb.CDATA2String(r.Content)
+		return msg, nil
+	case *RecvRespImageDataPkg:
+		msg := &SendMsgImagePkg{AgentID: agentID}
+		msg.ToUser = pb.CDATA2String(r.ToUserName)
+		msg.MsgType = ImageMsg
+		msg.Image.MediaID = pb.CDATA2String(r.Image.MediaID)
+		return msg, nil
+	case *RecvRespVoiceDataPkg:
+		return &SendMsgVoicePkg{
+			ToUser:  pb.CDATA2String(r.ToUserName),
+			MsgType: VoiceMsg,
+			AgentID: agentID,
+			Voice:   pb.MediaID{MediaID: pb.CDATA2String(r.Voice.MediaID)},
+		}, nil
+	case *RecvRespVideoDataPkg:
+		return &SendMsgVideoPkg{
+			ToUser:  pb.CDATA2String(r.ToUserName),
+			MsgType: VideoMsg,
+			AgentID: agentID,
+			Video: VideoContent{
+				MediaID:     pb.CDATA2String(r.Video.MediaID),
+				Title:       pb.CDATA2String(r.Video.Title),
+				Description: pb.CDATA2String(r.Video.Description),
+			},
+		}, nil
+	case *RecvRespNewsDataPkg:
+		msg := &SendMsgNewsPkg{
+			ToUserName: pb.CDATA2String(r.ToUserName),
+			MsgType:    NewsMsg,
+			AgentID:    agentID,
+		}
+		for _, a := range r.Articles {
+			msg.News.Articles = append(msg.News.Articles, Article{
+				Title:       pb.CDATA2String(a.Title),
+				Description: pb.CDATA2String(a.Description),
+				Url:         pb.CDATA2String(a.URL),
+				PicUrl:      pb.CDATA2String(a.PicURL),
+			})
+		}
+		return msg, nil
+	}
+	return reply, nil
+}
@@ -0,0 +1,132 @@
+package qy_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bigwhite/gowechat/pb"
+	"github.com/bigwhite/gowechat/qy"
+)
+
+var recvMenu = &qy.RecvMenuEventDataPkg{
+	RecvBaseDataPkg: pb.RecvBaseDataPkg{
+		ToUserName:   serverCorpID,
+		FromUserName: "baim",
+		CreateTime:   1426498001,
+		MsgType:      qy.EventMsg,
+	},
+	Event:    qy.MenuClickEvent,
+	EventKey: "s2-item1",
+	AgentID:  3,
+}
+
+func TestReplyEncrypted(t *testing.T) {
+	recv := qy.NewRecvHandler(serverCorpID, serverToken, encodingAESKey)
+
+	tests := []struct {
+		reply pb.Reply
+		want  string
+	}{
+		{qy.NewRecvRespTextDataPkg(recvMenu, "hello"),
+			`<MsgType><![CDATA[text]]></MsgType><Content><![CDATA[hello]]></Content>`},
+		{qy.NewRecvRespImageDataPkg(recvMenu, "media1"),
+			`<MsgType><![CDATA[image]]></MsgType><Image><MediaId><![CDATA[media1]]></MediaId></Image>`},
+		{qy.NewRecvRespVoiceDataPkg(recvMenu, "media2"),
+			`<MsgType><![CDATA[voice]]></MsgType><Voice><MediaId><![CDATA[media2]]></MediaId></Voice>`},
+		{qy.NewRecvRespVideoDataPkg(recvMenu, "media3", "title", "desc"),
+			`<MsgType><![CDATA[video]]></MsgType><Video><MediaId><![CDATA[media3]]></MediaId>` +
+				`<Title><![CDATA[title]]></Title><Description><![CDATA[desc]]></Description></Video>`},
+		{qy.NewRecvRespNewsDataPkg(recvMenu, qy.Article{Title: "title1", Description: "desc1", PicUrl: "http://p.qq.com/1.jpg", Url: "http://www.qq.com/1"}),
+			`<MsgType><![CDATA[news]]></MsgType><ArticleCount>1</ArticleCount><Articles><item>` +
+				`<Title><![CDATA[title1]]></Title><Description><![CDATA[desc1]]></Description>` +
+				`<PicUrl><![CDATA[http://p.qq.com/1.jpg]]></PicUrl><Url><![CDATA[http://www.qq.com/1]]></Url></item></Articles>`},
+		{qy.NewRecvRespUpdateButtonDataPkg(recvMenu, "done"),
+			`<MsgType><![CDATA[update_button]]></MsgType><Button><ReplaceName><![CDATA[done]]></ReplaceName></Button>`},
+	}
+	for _, tt := range tests {
+		body, err := pb.ResponseReply(recv, tt.reply, "")
+		if err != nil {
+			t.Fatalf("ResponseReply of %T error: %s", tt.reply, err)
+		}
+		msg := string(decryptReply(t, body))
+		if !strings.HasPrefix(msg, `<xml><ToUserName><![CDATA[baim]]></ToUserName><FromUserName><![CDATA[`+serverCorpID+`]]></FromUserName>`) {
+			t.Errorf("Reply of %T: want it to baim from %s, but actually[%s]", tt.reply, serverCorpID, msg)
+		}
+		if !strings.Contains(msg, tt.want) {
+			t.Errorf("Reply of %T: want[%s] in it, but actually[%s]", tt.reply, tt.want, msg)
+		}
+	}
+}
+
+func TestReplyNewsUnmarshal(t *testing.T) {
+	pkg := qy.NewRecvRespNewsDataPkg(recvMenu,
+		qy.Article{Title: "title1", Url: "http://www.qq.com/1"},
+		qy.Article{Title: "title2", Url: "http://www.qq.com/2"})
+	data, err := xml.Marshal(pkg)
+	if err != nil {
+		t.Fatal("Xml marshalling error:", err)
+	}
+
+	news := &struct {
+		ArticleCount int
+		Articles     []struct {
+			Title string
+			URL   string `xml:"Url"`
+		} `xml:"Articles>item"`
+	}{}
+	if err := xml.Unmarshal(data, news); err != nil {
+		t.Fatal("Xml decoding error:", err)
+	}
+	if news.ArticleCount != 2 || len(news.Articles) != 2 {
+		t.Fatalf("ArticleCount: want[2], but actually[%d %d]", news.ArticleCount, len(news.Articles))
+	}
+	if news.Articles[1].Title != "title2" || news.Articles[1].URL != "http://www.qq.com/2" {
+		t.Errorf("Article: want[title2 http://www.qq.com/2], but actually[%s %s]", news.Articles[1].Title, news.Articles[1].URL)
+	}
+}
+
+func TestClientDeliverReply(t *testing.T) {
+	var sent []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cgi-bin/gettoken", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token": "` + accessToken + `", "expires_in": 7200}`))
+	})
+	mux.HandleFunc("/cgi-bin/message/send", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		sent = append(sent, string(body))
+		w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := qy.NewClient(serverCorpID, "secret")
+	c.BaseURL = ts.URL
+
+	replies := []pb.Reply{
+		qy.NewRecvRespTextDataPkg(recvMenu, "hello"),
+		qy.NewRecvRespNewsDataPkg(recvMenu, qy.Article{Title: "title1", Url: "http://www.qq.com/1"}),
+	}
+	for _, reply := range replies {
+		if err := c.DeliverReply(context.Background(), recvMenu, reply); err != nil {
+			t.Fatalf("DeliverReply of %T error: %s", reply, err)
+		}
+	}
+	want := []string{
+		`{"touser":"baim","msgtype":"text","text":{"content":"hello"},"agentid":"3"}`,
+		`{"touser":"baim","msgtype":"news","agentid":"3","news":{"articles":[{"title":"title1","url":"http://www.qq.com/1"}]}}`,
+	}
+	for i := range want {
+		if i >= len(sent) || sent[i] != want[i] {
+			t.Errorf("Sent: want[%s], but actually[%v]", want[i], sent)
+		}
+	}
+
+	if err := c.DeliverReply(context.Background(), recvMenu, qy.NewRecvRespUpdateButtonDataPkg(recvMenu, "done")); err == nil {
+		t.Error("DeliverReply of update_button: want an error, but actually nil")
+	}
+}
@@ -0,0 +1,150 @@
+// Package qy provides the message router for wechat qy dev.
+package qy
+
+import (
+	"context"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+// Router dispatches the packages parsed by the RecvHandler of NewRecvHandler
+// to the typed handlers registered on it, see pb.Router for the order.
+// It could be passed to NewServer as the handler.
+type Router struct {
+	*pb.Router
+}
+
+// NewRouter creates an empty Router.
+func NewRouter() *Router {
+	return &Router{pb.NewRouter()}
+}
+
+// OnText registers h for text messages.
+func (r *Router) OnText(h func(ctx context.Context, pkg *RecvTextDataPkg) pb.Reply) {
+	r.HandleType((*RecvTextDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvTextDataPkg))
+	}))
+}
+
+// OnImage registers h for image messages.
+func (r *Router) OnImage(h func(ctx context.Context, pkg *RecvImageDataPkg) pb.Reply) {
+	r.HandleType((*RecvImageDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvImageDataPkg))
+	}))
+}
+
+// OnVoice registers h for voice messages.
+func (r *Router) OnVoice(h func(ctx context.Context, pkg *RecvVoiceDataPkg) pb.Reply) {
+	r.HandleType((*RecvVoiceDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvVoiceDataPkg))
+	}))
+}
+
+// OnVideo registers h for video messages.
+func (r *Router) OnVideo(h func(ctx context.Context, pkg *RecvVideoDataPkg) pb.Reply) {
+	r.HandleType((*RecvVideoDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvVideoDataPkg))
+	}))
+}
+
+// OnLocation registers h for location messages.
+func (r *Router) OnLocation(h func(ctx context.Context, pkg *RecvLocationDataPkg) pb.Reply) {
+	r.HandleType((*RecvLocationDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvLocationDataPkg))
+	}))
+}
+
+// OnSubscribeEvent registers h for subscribe and unsubscribe events.
+func (r *Router) OnSubscribeEvent(h func(ctx context.Context, pkg *RecvSubscribeEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvSubscribeEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvSubscribeEventDataPkg))
+	}))
+}
+
+// OnLocationEvent registers h for location report events.
+func (r *Router) OnLocationEvent(h func(ctx context.Context, pkg *RecvLocationEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvLocationEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvLocationEventDataPkg))
+	}))
+}
+
+// OnMenuEvent registers h for menu click and view events.
+func (r *Router) OnMenuEvent(h func(ctx context.Context, pkg *RecvMenuEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvMenuEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvMenuEventDataPkg))
+	}))
+}
+
+// OnEnterAgentEvent registers h for enter agent events.
+func (r *Router) OnEnterAgentEvent(h func(ctx context.Context, pkg *RecvEnterAgentDataPkg) pb.Reply) {
+	r.HandleType((*RecvEnterAgentDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvEnterAgentDataPkg))
+	}))
+}
+
+// OnScanCodeEvent registers h for scancode_push and scancode_waitmsg events.
+func (r *Router) OnScanCodeEvent(h func(ctx context.Context, pkg *RecvScanCodeEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvScanCodeEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvScanCodeEventDataPkg))
+	}))
+}
+
+// OnPicEvent registers h for pic_sysphoto, pic_photo_or_album and
+// pic_weixin events.
+func (r *Router) OnPicEvent(h func(ctx context.Context, pkg *RecvPicEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvPicEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvPicEventDataPkg))
+	}))
+}
+
+// OnLocationSelectEvent registers h for location_select events.
+func (r *Router) OnLocationSelectEvent(h func(ctx context.Context, pkg *RecvLocationSelectEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvLocationSelectEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvLocationSelectEventDataPkg))
+	}))
+}
+
+// OnUserChangeEvent registers h for change_contact events of users.
+func (r *Router) OnUserChangeEvent(h func(ctx context.Context, pkg *RecvUserChangeEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvUserChangeEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvUserChangeEventDataPkg))
+	}))
+}
+
+// OnPartyChangeEvent registers h for change_contact events of departments.
+func (r *Router) OnPartyChangeEvent(h func(ctx context.Context, pkg *RecvPartyChangeEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvPartyChangeEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvPartyChangeEventDataPkg))
+	}))
+}
+
+// OnTagChangeEvent registers h for change_contact events of tags.
+func (r *Router) OnTagChangeEvent(h func(ctx context.Context, pkg *RecvTagChangeEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvTagChangeEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvTagChangeEventDataPkg))
+	}))
+}
+
+// OnBatchJobResultEvent registers h for batch_job_result events.
+func (r *Router) OnBatchJobResultEvent(h func(ctx context.Context, pkg *RecvBatchJobResultEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvBatchJobResultEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvBatchJobResultEventDataPkg))
+	}))
+}
+
+// OnApprovalChangeEvent registers h for sys_approval_change events.
+func (r *Router) OnApprovalChangeEvent(h func(ctx context.Context, pkg *RecvApprovalChangeEventDataPkg) pb.Reply) {
+	r.HandleType((*RecvApprovalChangeEventDataPkg)(nil), pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvApprovalChangeEventDataPkg))
+	}))
+}
+
+// OnMenuKey registers h for the click and view events of the menu item
+// with key. The key of a view menu item is its url.
+func (r *Router) OnMenuKey(key string, h func(ctx context.Context, pkg *RecvMenuEventDataPkg) pb.Reply) {
+	handler := pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return h(ctx, pkg.(*RecvMenuEventDataPkg))
+	})
+	r.HandleEventKey(MenuClickEvent, key, handler)
+	r.HandleEventKey(MenuViewEvent, key, handler)
+}
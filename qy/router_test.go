@@ -0,0 +1,40 @@
+package qy_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bigwhite/gowechat/pb"
+	"github.com/bigwhite/gowechat/qy"
+)
+
+func TestRouter(t *testing.T) {
+	r := qy.NewRouter()
+	r.OnText(func(ctx context.Context, pkg *qy.RecvTextDataPkg) pb.Reply {
+		return "text:" + pkg.Content
+	})
+	r.OnMenuKey("s2-item1", func(ctx context.Context, pkg *qy.RecvMenuEventDataPkg) pb.Reply {
+		return "menu:" + pkg.EventKey
+	})
+	r.OnEnterAgentEvent(func(ctx context.Context, pkg *qy.RecvEnterAgentDataPkg) pb.Reply {
+		return "enter"
+	})
+	r.Default(func(ctx context.Context, pkg interface{}) pb.Reply {
+		return "default"
+	})
+
+	tests := []struct {
+		pkg  interface{}
+		want pb.Reply
+	}{
+		{&qy.RecvTextDataPkg{Content: "hello body"}, "text:hello body"},
+		{&qy.RecvMenuEventDataPkg{Event: qy.MenuClickEvent, EventKey: "s2-item1"}, "menu:s2-item1"},
+		{&qy.RecvMenuEventDataPkg{Event: qy.MenuClickEvent, EventKey: "s2-item2"}, "default"},
+		{&qy.RecvEnterAgentDataPkg{Event: qy.EnterAgentEvent}, "enter"},
+	}
+	for _, tt := range tests {
+		if got := r.ServeMsg(context.Background(), tt.pkg); got != tt.want {
+			t.Errorf("Reply of %T: want[%v], but actually[%v]", tt.pkg, tt.want, got)
+		}
+	}
+}
@@ -1,15 +1,12 @@
 package qy
 
 import (
-	"strings"
+	"context"
+	"net/url"
 
 	"github.com/bigwhite/gowechat/pb"
 )
 
-const (
-	sendURL = "https://qyapi.weixin.qq.com/cgi-bin/message/send"
-)
-
 type SendMsgTextPkg struct {
 	pb.SendMsgTextPkg
 	ToParty string `json:"toparty,omitempty"`
@@ -26,6 +23,35 @@ type SendMsgImagePkg struct {
 	Safe    string `json:"safe,omitempty"`
 }
 
+// SendMsgVoicePkg is a Voice message sent by an agent.
+type SendMsgVoicePkg struct {
+	ToUser  string     `json:"touser,omitempty"`
+	ToParty string     `json:"toparty,omitempty"`
+	ToTag   string     `json:"totag,omitempty"`
+	MsgType string     `json:"msgtype"`
+	AgentID string     `json:"agentid"`
+	Voice   pb.MediaID `json:"voice"`
+	Safe    string     `json:"safe,omitempty"`
+}
+
+// VideoContent is the video of a Video message.
+type VideoContent struct {
+	MediaID     string `json:"media_id"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// SendMsgVideoPkg is a Video message sent by an agent.
+type SendMsgVideoPkg struct {
+	ToUser  string       `json:"touser,omitempty"`
+	ToParty string       `json:"toparty,omitempty"`
+	ToTag   string       `json:"totag,omitempty"`
+	MsgType string       `json:"msgtype"`
+	AgentID string       `json:"agentid"`
+	Video   VideoContent `json:"video"`
+	Safe    string       `json:"safe,omitempty"`
+}
+
 type Article struct {
 	Title       string `json:"title,omitempty"`
 	Description string `json:"description,omitempty"`
@@ -46,6 +72,23 @@ type SendMsgNewsPkg struct {
 }
 
 func SendMsg(accessToken string, pkg interface{}) error {
-	r := strings.Join([]string{sendURL, "?access_token=", accessToken}, "")
-	return pb.SendMsg(r, pkg)
+	return SendMsgContext(context.Background(), accessToken, pkg)
+}
+
+// SendMsgContext is like SendMsg with a context.
+func SendMsgContext(ctx context.Context, accessToken string, pkg interface{}) error {
+	query := url.Values{}
+	query.Set("access_token", accessToken)
+	return pb.SendMsgContext(ctx, pb.NewClient(DefaultBaseURL), sendPath, query, pkg)
+}
+
+// SendMsg sends pkg to the users, parties or tags in it
+// with the access token of c.
+func (c *Client) SendMsg(pkg interface{}) error {
+	return c.SendMsgContext(context.Background(), pkg)
+}
+
+// SendMsgContext is like SendMsg with a context.
+func (c *Client) SendMsgContext(ctx context.Context, pkg interface{}) error {
+	return pb.SendMsgContext(ctx, c.Client, sendPath, nil, pkg)
 }
@@ -0,0 +1,32 @@
+// Package qy provides the callback server for wechat qy dev.
+package qy
+
+import (
+	"net/url"
+
+	"github.com/bigwhite/gowechat/pb"
+)
+
+// NewServer creates an http.Handler serving the callback url of corpID.
+// It validates the url verification requests with ValidateURL, parses the
+// messages with the RecvHandler of NewRecvHandler, and responds with the
+// encrypted replies of handler. pb.WithReplayGuard in opts sets the Guard
+// of the Server, which guards the url verification requests too.
+func NewServer(corpID, token, encodingAESKey string, handler pb.MsgHandler, opts ...pb.RecvOption) *pb.Server {
+	// The Server checks the nonces after Dedupe, so that the retries of
+	// wechat platform are answered with the cached replies.
+	guard := pb.NewRecvOptions(opts...).Guard.WithScope(corpID)
+	verify := func(query url.Values) ([]byte, bool) {
+		timestamp, nonce := query.Get("timestamp"), query.Get("nonce")
+		ok, echoStr := ValidateURL(query.Get("msg_signature"), token, timestamp,
+			nonce, query.Get("echostr"), encodingAESKey)
+		if ok && guard != nil && guard.Check(timestamp, nonce) != nil {
+			return nil, false
+		}
+		return echoStr, ok
+	}
+
+	s := pb.NewServer(NewRecvHandler(corpID, token, encodingAESKey), verify, handler)
+	s.Guard = guard
+	return s
+}
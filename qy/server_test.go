@@ -0,0 +1,141 @@
+package qy_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bigwhite/gowechat/pb"
+	"github.com/bigwhite/gowechat/qy"
+)
+
+const (
+	serverCorpID = "wx2f6d0a549c129f06"
+	serverToken  = "wechat4go"
+	textMsg      = `<xml>
+<ToUserName><![CDATA[wx2f6d0a549c129f06]]></ToUserName>
+<FromUserName><![CDATA[baim]]></FromUserName>
+<CreateTime>1426498001</CreateTime>
+<MsgType><![CDATA[text]]></MsgType>
+<Content><![CDATA[hello body]]></Content>
+<MsgId>000001</MsgId>
+<AgentID>3</AgentID>
+</xml>`
+)
+
+var echoHandler = pb.MsgHandlerFunc(func(ctx context.Context, pkg interface{}) pb.Reply {
+	text, ok := pkg.(*qy.RecvTextDataPkg)
+	if !ok {
+		return nil
+	}
+	return &qy.RecvRespTextDataPkg{
+		RecvRespBaseDataPkg: pb.RecvRespBaseDataPkg{
+			ToUserName:   pb.String2CDATA(text.FromUserName),
+			FromUserName: pb.String2CDATA(text.ToUserName),
+			CreateTime:   text.CreateTime,
+			MsgType:      pb.String2CDATA(qy.TextMsg),
+		},
+		Content: pb.String2CDATA(text.Content),
+	}
+})
+
+// postEncrypted posts msg encrypted and signed like wechat qy platform.
+func postEncrypted(t *testing.T, serverURL, msg string) []byte {
+	msgEncrypt, err := qy.EncryptMsg([]byte(msg), serverCorpID, encodingAESKey)
+	if err != nil {
+		t.Fatal("EncryptMsg error:", err)
+	}
+	reqBody := "<xml><ToUserName><![CDATA[" + serverCorpID + "]]></ToUserName><AgentID><![CDATA[3]]></AgentID><Encrypt><![CDATA[" +
+		msgEncrypt + "]]></Encrypt></xml>"
+
+	query := url.Values{}
+	query.Set("timestamp", "1426498001")
+	query.Set("nonce", "1019369511")
+	query.Set("msg_signature", pb.GenSignature(serverToken, "1426498001", "1019369511", msgEncrypt))
+	resp, err := http.Post(serverURL+"?"+query.Encode(), "text/xml", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatal("Post error:", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return body
+}
+
+// decryptReply decrypts the encrypted reply in body.
+func decryptReply(t *testing.T, body []byte) []byte {
+	respBody := &struct {
+		Encrypt      string
+		MsgSignature string
+		TimeStamp    string
+		Nonce        string
+	}{}
+	if err := xml.Unmarshal(body, respBody); err != nil {
+		t.Fatalf("Xml decoding [%s] error: %s", body, err)
+	}
+	if !qy.ValidateSignature(respBody.MsgSignature, serverToken, respBody.TimeStamp, respBody.Nonce, respBody.Encrypt) {
+		t.Error("want a valid reply signature, but actually it is not")
+	}
+
+	msg, _, corpID, err := qy.DecryptMsg(respBody.Encrypt, encodingAESKey)
+	if err != nil {
+		t.Fatal("DecryptMsg error:", err)
+	}
+	if corpID != serverCorpID {
+		t.Errorf("Corpid: want[%s], but actually[%s]", serverCorpID, corpID)
+	}
+	return msg
+}
+
+func TestServerVerifyURL(t *testing.T) {
+	s := qy.NewServer(serverCorpID, serverToken, encodingAESKey, echoHandler)
+
+	query := url.Values{}
+	query.Set("msg_signature", "61b23841affc32e28a339764e43a9679f38ad17d")
+	query.Set("timestamp", "1426129452")
+	query.Set("nonce", "1019369511")
+	query.Set("echostr", "esO4Svu/v89CuQ07sXQVHN9alKpivaxlO++FrgwNaIC+oeFMQa6FC0u5OtiNb+GjRo352TIvlTjiN/xEsRaX0Q==")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/callback?"+query.Encode(), nil))
+	if w.Code != http.StatusOK || w.Body.String() != "4362985891886127916" {
+		t.Errorf("Response: want[200 %s], but actually[%d %s]", "4362985891886127916", w.Code, w.Body.String())
+	}
+
+	query.Set("msg_signature", "61b23841affc32e28a339764e43a9679f38ad17x")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/callback?"+query.Encode(), nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Status: want[%d], but actually[%d]", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestServerMsg(t *testing.T) {
+	ts := httptest.NewServer(qy.NewServer(serverCorpID, serverToken, encodingAESKey, echoHandler))
+	defer ts.Close()
+
+	msg := decryptReply(t, postEncrypted(t, ts.URL, textMsg))
+	reply := &struct {
+		ToUserName string
+		MsgType    string
+		Content    string
+	}{}
+	if err := xml.Unmarshal(msg, reply); err != nil {
+		t.Fatalf("Xml decoding [%s] error: %s", msg, err)
+	}
+	if reply.ToUserName != "baim" || reply.MsgType != "text" || reply.Content != "hello body" {
+		t.Errorf("Reply: want[baim text hello body], but actually%+v", reply)
+	}
+
+	// No reply for the other messages.
+	enterAgent := `<xml><ToUserName><![CDATA[wx2f6d0a549c129f06]]></ToUserName><FromUserName><![CDATA[baim]]></FromUserName>
+<CreateTime>1426498001</CreateTime><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[enter_agent]]></Event>
+<EventKey><![CDATA[]]></EventKey><AgentID>3</AgentID></xml>`
+	if body := postEncrypted(t, ts.URL, enterAgent); string(body) != "success" {
+		t.Errorf("Reply: want[%s], but actually[%s]", "success", body)
+	}
+}